@@ -0,0 +1,15 @@
+package hashfs
+
+// addressedPrefix is the path prefix of content addressed paths.
+const addressedPrefix = "_ca/"
+
+// WithContentAddressed makes paths address files purely by the full hash of
+// their content, for example _ca/<hash>, so files with identical content share
+// a single path. Since the path does not include the file name, requests can
+// only be served for files which have been hashed, so Precompute or
+// LoadManifest should be called before serving requests.
+func WithContentAddressed() Option {
+	return func(s *Server) {
+		s.contentAddressed = true
+	}
+}
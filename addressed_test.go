@@ -0,0 +1,65 @@
+package hashfs
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/daaku/ensure"
+)
+
+func TestContentAddressed(t *testing.T) {
+	fsys := fstest.MapFS{
+		"a/chunk.js": {Data: []byte("chunk")},
+		"b/chunk.js": {Data: []byte("chunk")},
+		"main.js":    {Data: []byte("main")},
+	}
+	s := New(fsys, WithContentAddressed())
+	ensure.Nil(t, s.Precompute())
+	ensure.DeepEqual(t, s.Path("a/chunk.js"), s.Path("b/chunk.js"))
+	hashed := s.Path("main.js")
+	ensure.DeepEqual(t, hashed, "_ca/0d6e4079e36703ebd37c00722f5891d28b0e2811dc114b129215123adcce3605")
+
+	cases := []struct {
+		path, body string
+		code       int
+	}{
+		{"/" + hashed, "main", http.StatusOK},
+		{"/" + s.Path("a/chunk.js"), "chunk", http.StatusOK},
+		{"/_ca/0000", "", http.StatusNotFound},
+		{"/main.js", "", http.StatusNotFound},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest("GET", c.path, nil)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		ensure.DeepEqual(t, w.Code, c.code)
+		if c.code == http.StatusOK {
+			ensure.DeepEqual(t, w.Body.String(), c.body)
+			ensure.DeepEqual(t, w.Header().Get("Content-Type"), "text/javascript; charset=utf-8")
+		}
+	}
+
+	m := New(fsys, WithContentAddressed())
+	ensure.Nil(t, m.LoadManifest(strings.NewReader(`{"main.js": "_ca/abcdef"}`)))
+	filename, err := m.Unhashed("_ca/abcdef")
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, filename, "main.js")
+	ensure.NotNil(t, m.LoadManifest(strings.NewReader(`{"main.js": "main.abcdef.js"}`)))
+}
+
+func TestContentAddressedReferences(t *testing.T) {
+	s := New(referencesFS, WithContentAddressed())
+	ensure.Nil(t, s.Precompute())
+	checkReferences(t, s, "css/main.css", 2)
+	checkReferences(t, s, "js/main.js", 1)
+
+	// references are relative to the _ca directory
+	r := httptest.NewRequest("GET", "/"+s.Path("css/main.css"), nil)
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	hashed := strings.TrimPrefix(s.Path("img/logo.png"), addressedPrefix)
+	ensure.StringContains(t, w.Body.String(), "url("+hashed+")")
+}
@@ -0,0 +1,127 @@
+// Package bucketsync uploads the hashed files of a hashfs.Server to an S3 or
+// GCS compatible bucket, for serving from static hosting or a CDN.
+//
+// The Bucket interface is small enough to implement with any storage client,
+// so this package does not depend on a specific SDK.
+package bucketsync
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"maps"
+	"mime"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/daaku/hashfs"
+)
+
+// Bucket is the subset of a storage client used by Sync.
+type Bucket interface {
+	// Exists returns true if an object with the key exists.
+	Exists(ctx context.Context, key string) (bool, error)
+	// Put creates or replaces the object with the key.
+	Put(ctx context.Context, key string, data []byte, meta Metadata) error
+	// List returns the keys of all objects with the prefix.
+	List(ctx context.Context, prefix string) ([]string, error)
+	// Delete removes the object with the key.
+	Delete(ctx context.Context, key string) error
+}
+
+// Metadata is set on uploaded objects.
+type Metadata struct {
+	ContentType  string
+	CacheControl string
+}
+
+// Options configure Sync.
+type Options struct {
+	// Prefix is prepended to the hashed paths to make the object keys.
+	Prefix string
+	// CacheControl is set on objects with hashed paths. The default is
+	// immutable caching for one year.
+	CacheControl string
+	// DeleteOrphans deletes objects with the prefix which are not part of the
+	// current files.
+	DeleteOrphans bool
+}
+
+// Result lists the object keys changed by Sync.
+type Result struct {
+	Uploaded []string
+	Skipped  []string
+	Deleted  []string
+}
+
+// Sync uploads every file of the Server to the bucket using its hashed path.
+// Since hashed paths are content addressed, objects which already exist are
+// skipped. Files excluded from hashing are always uploaded, with
+// Cache-Control: no-cache.
+func Sync(ctx context.Context, s *hashfs.Server, b Bucket, opts Options) (*Result, error) {
+	if opts.CacheControl == "" {
+		opts.CacheControl = "public, immutable, max-age=31536000"
+	}
+	manifest, err := s.Manifest()
+	if err != nil {
+		return nil, err
+	}
+	fsys := s.FS()
+	var result Result
+	keys := make(map[string]bool, len(manifest))
+	for _, filename := range slices.Sorted(maps.Keys(manifest)) {
+		hashed := manifest[filename]
+		// the query of a query versioned path is not part of the file name
+		name, _, _ := strings.Cut(hashed, "?")
+		key := opts.Prefix + name
+		keys[key] = true
+		meta := Metadata{CacheControl: opts.CacheControl}
+		if name == filename {
+			meta.CacheControl = "no-cache"
+		} else {
+			exists, err := b.Exists(ctx, key)
+			if err != nil {
+				return nil, fmt.Errorf("bucketsync: error checking %q: %w", key, err)
+			}
+			if exists {
+				result.Skipped = append(result.Skipped, key)
+				continue
+			}
+		}
+		data, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return nil, fmt.Errorf("bucketsync: error reading %q: %w", filename, err)
+		}
+		meta.ContentType = contentType(filename, data)
+		if err := b.Put(ctx, key, data, meta); err != nil {
+			return nil, fmt.Errorf("bucketsync: error uploading %q: %w", key, err)
+		}
+		result.Uploaded = append(result.Uploaded, key)
+	}
+	if !opts.DeleteOrphans {
+		return &result, nil
+	}
+	existing, err := b.List(ctx, opts.Prefix)
+	if err != nil {
+		return nil, fmt.Errorf("bucketsync: error listing %q: %w", opts.Prefix, err)
+	}
+	for _, key := range existing {
+		if keys[key] {
+			continue
+		}
+		if err := b.Delete(ctx, key); err != nil {
+			return nil, fmt.Errorf("bucketsync: error deleting %q: %w", key, err)
+		}
+		result.Deleted = append(result.Deleted, key)
+	}
+	return &result, nil
+}
+
+func contentType(filename string, data []byte) string {
+	if ctype := mime.TypeByExtension(path.Ext(filename)); ctype != "" {
+		return ctype
+	}
+	return http.DetectContentType(data[:min(len(data), 512)])
+}
@@ -0,0 +1,73 @@
+package bucketsync
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/daaku/ensure"
+	"github.com/daaku/hashfs"
+)
+
+type object struct {
+	data []byte
+	meta Metadata
+}
+
+type memBucket map[string]object
+
+func (b memBucket) Exists(ctx context.Context, key string) (bool, error) {
+	_, found := b[key]
+	return found, nil
+}
+
+func (b memBucket) Put(ctx context.Context, key string, data []byte, meta Metadata) error {
+	b[key] = object{data: data, meta: meta}
+	return nil
+}
+
+func (b memBucket) List(ctx context.Context, prefix string) ([]string, error) {
+	var keys []string
+	for key := range b {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+func (b memBucket) Delete(ctx context.Context, key string) error {
+	delete(b, key)
+	return nil
+}
+
+func TestSync(t *testing.T) {
+	s := hashfs.New(fstest.MapFS{
+		"main.js": {Data: []byte("main")},
+		"sw.js":   {Data: []byte("sw")},
+	}, hashfs.WithExclude("sw.js"))
+	b := memBucket{
+		"static/main.0d6e4079e367.js": {data: []byte("main")},
+		"static/old.abcdef.js":        {data: []byte("old")},
+		"other/keep.js":               {data: []byte("keep")},
+	}
+	result, err := Sync(context.Background(), s, b, Options{Prefix: "static/", DeleteOrphans: true})
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, result, &Result{
+		Uploaded: []string{"static/sw.js"},
+		Skipped:  []string{"static/main.0d6e4079e367.js"},
+		Deleted:  []string{"static/old.abcdef.js"},
+	})
+	ensure.DeepEqual(t, b["static/sw.js"].meta, Metadata{
+		ContentType:  "text/javascript; charset=utf-8",
+		CacheControl: "no-cache",
+	})
+	ensure.DeepEqual(t, len(b), 3)
+
+	delete(b, "static/main.0d6e4079e367.js")
+	result, err = Sync(context.Background(), s, b, Options{Prefix: "static/"})
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, result.Uploaded, []string{"static/main.0d6e4079e367.js", "static/sw.js"})
+	ensure.DeepEqual(t, b["static/main.0d6e4079e367.js"].meta.CacheControl, "public, immutable, max-age=31536000")
+}
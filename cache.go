@@ -0,0 +1,113 @@
+package hashfs
+
+import (
+	"io/fs"
+	"sync/atomic"
+)
+
+// Invalidate drops the cached data for filename from the default Server for
+// the file system.
+func Invalidate(fs fs.FS, filename string) {
+	defaultServer(fs).Invalidate(filename)
+}
+
+// ResetCache drops all cached data from the default Server for the file
+// system.
+func ResetCache(fs fs.FS) {
+	defaultServer(fs).ResetCache()
+}
+
+// Invalidate drops the cached data for filename, such as after it has changed.
+// Since CSS and JavaScript files embed the hashed paths of the files they
+// reference, the data for all of them is dropped as well, along with that of
+// the images filename may be a negotiated variant of. Hashes loaded from a
+// manifest are kept, since they describe the deployed files. Hashes being
+// computed while Invalidate is called are returned but not cached.
+func (s *Server) Invalidate(filename string) {
+	s.forget(filename)
+	s.hashes.Range(func(key, _ any) bool {
+		if name := key.(string); isRewritable(name) || s.mayHaveVariant(name, filename) {
+			s.forget(name)
+		}
+		return true
+	})
+}
+
+func (s *Server) forget(filename string) {
+	// computations in progress may have read the old content
+	s.generation.Add(1)
+	s.inflight.Delete(filename)
+	if cached, found := s.hashes.Load(filename); found {
+		e := cached.(*hashEntry)
+		if !e.manifest {
+			s.hashes.CompareAndDelete(filename, cached)
+		}
+		hashed := e.path
+		s.compressedCache.deleteFunc(func(key compressedKey) bool {
+			return key.hashed == hashed
+		})
+	}
+	s.css.Delete(filename)
+	s.js.Delete(filename)
+	s.integrities.Delete(filename)
+	s.siblings.Delete(filename)
+	s.missing.Delete(filename)
+}
+
+// ResetCache drops all cached data.
+func (s *Server) ResetCache() {
+	s.generation.Add(1)
+	s.inflight.Clear()
+	s.hashes.Clear()
+	s.css.Clear()
+	s.js.Clear()
+	s.integrities.Clear()
+	s.siblings.Clear()
+	s.compressedCache.clear()
+	s.missing.Clear()
+	s.addressed.Clear()
+	if s.contentCache != nil {
+		s.contentCache.clear()
+	}
+}
+
+// Stats describes the state of the caches of a Server.
+type Stats struct {
+	HashEntries int   // files in the hash cache
+	HashHits    int64 // hash cache lookups which found the file
+	HashMisses  int64 // hash cache lookups which did not find the file
+
+	ContentEntries   int   // files in the content cache
+	ContentBytes     int64 // total size of the files in the content cache
+	ContentHits      int64 // content cache lookups which found the file
+	ContentMisses    int64 // content cache lookups which did not find the file
+	ContentEvictions int64 // files evicted from the content cache
+}
+
+// cacheCounters counts the lookups in the caches.
+type cacheCounters struct {
+	hashHits, hashMisses       atomic.Int64
+	contentHits, contentMisses atomic.Int64
+}
+
+// Stats returns the current cache statistics.
+func (s *Server) Stats() Stats {
+	st := Stats{
+		HashHits:      s.counters.hashHits.Load(),
+		HashMisses:    s.counters.hashMisses.Load(),
+		ContentHits:   s.counters.contentHits.Load(),
+		ContentMisses: s.counters.contentMisses.Load(),
+	}
+	s.hashes.Range(func(_, _ any) bool {
+		st.HashEntries++
+		return true
+	})
+	if s.contentCache != nil {
+		s.contentCache.mu.Lock()
+		st.ContentEntries = s.contentCache.ll.Len()
+		st.ContentBytes = s.contentCache.size
+		st.ContentEvictions = s.contentCache.evictions
+		s.contentCache.mu.Unlock()
+	}
+	return st
+}
@@ -0,0 +1,151 @@
+package hashfs
+
+import (
+	"errors"
+	"io/fs"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/daaku/ensure"
+)
+
+func TestInvalidate(t *testing.T) {
+	fsys := fstest.MapFS{
+		"main.css":  {Data: []byte(`@import "other.css";`)},
+		"other.css": {Data: []byte("other")},
+		"main.js":   {Data: []byte("main")},
+	}
+	s := New(fsys)
+	mainCSS := s.Path("main.css")
+	otherCSS := s.Path("other.css")
+	mainJS := s.Path("main.js")
+
+	fsys["other.css"] = &fstest.MapFile{Data: []byte("changed")}
+	ensure.DeepEqual(t, s.Path("other.css"), otherCSS)
+	s.Invalidate("other.css")
+	ensure.NotDeepEqual(t, s.Path("other.css"), otherCSS)
+	ensure.NotDeepEqual(t, s.Path("main.css"), mainCSS)
+	ensure.DeepEqual(t, s.Path("main.js"), mainJS)
+}
+
+func TestInvalidateManifest(t *testing.T) {
+	fsys := fstest.MapFS{"main.js": {Data: []byte("main")}}
+	s := New(fsys)
+	ensure.Nil(t, s.LoadManifest(strings.NewReader(`{"main.js": "main.abcdef.js"}`)))
+	s.Invalidate("main.js")
+	ensure.DeepEqual(t, s.Path("main.js"), "main.abcdef.js")
+}
+
+// blockingFS opens files and then waits until released, so the content read
+// is from before the file is changed.
+type blockingFS struct {
+	fs.FS
+	opened, release chan struct{}
+}
+
+func (b blockingFS) Open(name string) (fs.File, error) {
+	f, err := b.FS.Open(name)
+	if name == "main.txt" {
+		b.opened <- struct{}{}
+		<-b.release
+	}
+	return f, err
+}
+
+func TestInvalidateWhileHashing(t *testing.T) {
+	fsys := fstest.MapFS{"main.txt": {Data: []byte("main")}}
+	s := New(blockingFS{FS: fsys, opened: make(chan struct{}), release: make(chan struct{})})
+	b := s.fs.(blockingFS)
+	done := make(chan string)
+	go func() { done <- s.Path("main.txt") }()
+	<-b.opened
+	fsys["main.txt"] = &fstest.MapFile{Data: []byte("changed")}
+	s.Invalidate("main.txt")
+	close(b.release)
+	ensure.DeepEqual(t, <-done, "main.0d6e4079e367.txt")
+
+	// the result read before Invalidate was not cached
+	go func() { <-b.opened }()
+	ensure.DeepEqual(t, s.Path("main.txt"), "main.d67e2e944994.txt")
+}
+
+func TestResetCache(t *testing.T) {
+	fsys := fstest.MapFS{"main.js": {Data: []byte("main")}}
+	s := New(fsys)
+	mainJS := s.Path("main.js")
+	fsys["main.js"] = &fstest.MapFile{Data: []byte("changed")}
+	ensure.DeepEqual(t, s.Path("main.js"), mainJS)
+	s.ResetCache()
+	ensure.NotDeepEqual(t, s.Path("main.js"), mainJS)
+}
+
+func TestRevalidate(t *testing.T) {
+	modTime := time.Unix(1700000000, 0)
+	fsys := fstest.MapFS{
+		"main.css": {Data: []byte(`@import "a.txt";`)},
+		"a.txt":    {Data: []byte("aaaa"), ModTime: modTime},
+	}
+	s := New(fsys, WithRevalidate(time.Millisecond))
+	a := s.Path("a.txt")
+	mainCSS := s.Path("main.css")
+
+	// the size and modification time are unchanged, so the file is not hashed
+	fsys["a.txt"] = &fstest.MapFile{Data: []byte("bbbb"), ModTime: modTime}
+	time.Sleep(2 * time.Millisecond)
+	ensure.DeepEqual(t, s.Path("a.txt"), a)
+	ensure.DeepEqual(t, s.Path("main.css"), mainCSS)
+
+	fsys["a.txt"] = &fstest.MapFile{Data: []byte("bbbb"), ModTime: modTime.Add(time.Second)}
+	time.Sleep(2 * time.Millisecond)
+	ensure.NotDeepEqual(t, s.Path("a.txt"), a)
+	ensure.NotDeepEqual(t, s.Path("main.css"), mainCSS)
+
+	delete(fsys, "a.txt")
+	time.Sleep(2 * time.Millisecond)
+	_, err := s.MaybePath("a.txt")
+	ensure.True(t, errors.Is(err, fs.ErrNotExist))
+}
+
+func TestNoCache(t *testing.T) {
+	fsys := fstest.MapFS{
+		"main.css": {Data: []byte(`@import "a.txt";`)},
+		"a.txt":    {Data: []byte("a")},
+	}
+	s := New(fsys, WithNoCache())
+	a := s.Path("a.txt")
+	mainCSS := s.Path("main.css")
+	unhashed, err := s.Unhashed(a)
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, unhashed, "a.txt")
+
+	fsys["a.txt"] = &fstest.MapFile{Data: []byte("b")}
+	ensure.NotDeepEqual(t, s.Path("a.txt"), a)
+	ensure.NotDeepEqual(t, s.Path("main.css"), mainCSS)
+	_, err = s.Unhashed(a)
+	ensure.True(t, errors.Is(err, ErrPathMismatch))
+	ensure.DeepEqual(t, s.Stats().HashEntries, 0)
+}
+
+func TestStats(t *testing.T) {
+	fsys := fstest.MapFS{
+		"a.txt": {Data: []byte("aaaa")},
+		"b.txt": {Data: []byte("bbbb")},
+	}
+	s := New(fsys, WithContentCache(6))
+	for _, name := range []string{"a.txt", "a.txt", "b.txt"} {
+		r := httptest.NewRequest("GET", "/"+s.Path(name), nil)
+		s.ServeHTTP(httptest.NewRecorder(), r)
+	}
+	st := s.Stats()
+	ensure.DeepEqual(t, st.HashEntries, 2)
+	ensure.True(t, st.HashHits > 0)
+	ensure.DeepEqual(t, st.HashMisses, int64(2))
+	ensure.DeepEqual(t, st.ContentEntries, 1)
+	ensure.DeepEqual(t, st.ContentBytes, int64(4))
+	ensure.DeepEqual(t, st.ContentHits, int64(1))
+	ensure.DeepEqual(t, st.ContentMisses, int64(2))
+	ensure.DeepEqual(t, st.ContentEvictions, int64(1))
+}
@@ -0,0 +1,89 @@
+// Command hashfs prints the JSON manifest mapping the files in a directory to
+// their hashed paths. With -out the files are also copied to the output
+// directory using their hashed paths. With -integrity the manifest also
+// includes the Subresource Integrity value of every file. With -sign-key the manifest is signed
+// using an ed25519 private key in PEM encoded PKCS #8 form, such as one
+// generated by "openssl genpkey -algorithm ed25519", and the signature is
+// written to the -sig file for use with LoadSignedManifest.
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/daaku/hashfs"
+)
+
+func main() {
+	out := flag.String("out", "", "directory to copy hashed files to")
+	integrity := flag.Bool("integrity", false, "include integrity values in the manifest")
+	signKey := flag.String("sign-key", "", "ed25519 private key file to sign the manifest with")
+	sig := flag.String("sig", "manifest.sig", "file to write the manifest signature to")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [dir]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	dir := "."
+	if flag.NArg() > 0 {
+		dir = flag.Arg(0)
+	}
+	if err := run(dir, *out, *integrity, *signKey, *sig); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(dir, out string, integrity bool, signKey, sig string) error {
+	fsys := os.DirFS(dir)
+	if out != "" {
+		if _, err := hashfs.CopyAll(out, fsys); err != nil {
+			return err
+		}
+	}
+	write := hashfs.WriteManifest
+	if integrity {
+		write = hashfs.WriteIntegrityManifest
+	}
+	var manifest bytes.Buffer
+	if err := write(fsys, &manifest); err != nil {
+		return err
+	}
+	if signKey != "" {
+		key, err := readKey(signKey)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(sig, hashfs.SignManifest(key, manifest.Bytes()), 0o644); err != nil {
+			return err
+		}
+	}
+	_, err := os.Stdout.Write(manifest.Bytes())
+	return err
+}
+
+func readKey(filename string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("hashfs: no PEM data in key file")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("hashfs: invalid key: %w", err)
+	}
+	edKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, errors.New("hashfs: key is not an ed25519 private key")
+	}
+	return edKey, nil
+}
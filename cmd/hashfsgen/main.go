@@ -0,0 +1,121 @@
+// Command hashfsgen generates a Go file with constants for the original and
+// hashed paths of the files in embedded directories, so typos in asset paths
+// are compile errors. It is meant to be used with go:generate next to the
+// go:embed directive:
+//
+//	//go:generate go run github.com/daaku/hashfs/cmd/hashfsgen -pkg assets -o assets_gen.go static
+//	//go:embed static
+//	var FS embed.FS
+//
+// This generates constants such as StaticMainJS = "static/main.js" and
+// StaticMainJSHashed = "static/main.60797db6e8ff.js". As with go:embed, files
+// starting with "." or "_" are skipped.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/format"
+	"io/fs"
+	"os"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/daaku/hashfs"
+)
+
+func main() {
+	pkg := flag.String("pkg", os.Getenv("GOPACKAGE"), "package name of the generated file")
+	out := flag.String("o", "hashfs_gen.go", "output file")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] dir...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if *pkg == "" || flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	src, err := generate(os.DirFS("."), *pkg, flag.Args())
+	if err == nil {
+		err = os.WriteFile(*out, src, 0o644)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func generate(fsys fs.FS, pkg string, dirs []string) ([]byte, error) {
+	var names []string
+	for _, dir := range dirs {
+		err := fs.WalkDir(fsys, dir, func(name string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if name != dir && (strings.HasPrefix(d.Name(), ".") || strings.HasPrefix(d.Name(), "_")) {
+				if d.IsDir() {
+					return fs.SkipDir
+				}
+				return nil
+			}
+			if !d.IsDir() {
+				names = append(names, name)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	slices.Sort(names)
+
+	s := hashfs.New(fsys)
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "// Code generated by hashfsgen. DO NOT EDIT.\n\npackage %s\n\nconst (\n", pkg)
+	seen := make(map[string]string)
+	for _, name := range names {
+		hashed, err := s.MaybePath(name)
+		if err != nil {
+			return nil, err
+		}
+		ident := identifier(name)
+		if other, found := seen[ident]; found {
+			return nil, fmt.Errorf("hashfsgen: %q and %q both map to %s", other, name, ident)
+		}
+		seen[ident] = name
+		fmt.Fprintf(&buf, "\t%s = %q\n\t%sHashed = %q\n", ident, name, ident, hashed)
+	}
+	buf.WriteString(")\n")
+	return format.Source(buf.Bytes())
+}
+
+// initialisms are written in upper case in identifiers.
+var initialisms = map[string]bool{
+	"css": true, "html": true, "js": true, "json": true, "svg": true,
+	"txt": true, "xml": true, "wasm": true, "pdf": true, "ico": true,
+}
+
+// identifier returns an exported Go identifier for the file name.
+func identifier(name string) string {
+	var b strings.Builder
+	words := strings.FieldsFunc(name, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for _, word := range words {
+		if initialisms[strings.ToLower(word)] {
+			b.WriteString(strings.ToUpper(word))
+			continue
+		}
+		r := []rune(word)
+		r[0] = unicode.ToUpper(r[0])
+		b.WriteString(string(r))
+	}
+	ident := b.String()
+	if ident == "" || !unicode.IsLetter([]rune(ident)[0]) {
+		ident = "File" + ident
+	}
+	return ident
+}
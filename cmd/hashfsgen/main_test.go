@@ -0,0 +1,46 @@
+package main
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/daaku/ensure"
+)
+
+func TestIdentifier(t *testing.T) {
+	cases := []struct {
+		name, ident string
+	}{
+		{"static/main.js", "StaticMainJS"},
+		{"static/sub/main.min.css", "StaticSubMainMinCSS"},
+		{"static/app-icon_2x.png", "StaticAppIcon2xPng"},
+		{"404.html", "File404HTML"},
+	}
+	for _, c := range cases {
+		ensure.DeepEqual(t, identifier(c.name), c.ident)
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	fsys := fstest.MapFS{
+		"static/main.js":     {Data: []byte("main")},
+		"static/.hidden":     {Data: []byte("hidden")},
+		"static/_skip/a.js":  {Data: []byte("a")},
+		"other/not-included": {Data: []byte("other")},
+	}
+	src, err := generate(fsys, "assets", []string{"static"})
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, string(src), `// Code generated by hashfsgen. DO NOT EDIT.
+
+package assets
+
+const (
+	StaticMainJS       = "static/main.js"
+	StaticMainJSHashed = "static/main.0d6e4079e367.js"
+)
+`)
+
+	fsys["static/main-js"] = &fstest.MapFile{Data: []byte("dup")}
+	_, err = generate(fsys, "assets", []string{"static"})
+	ensure.NotNil(t, err)
+}
@@ -0,0 +1,304 @@
+package hashfs
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// WithCompression enables on the fly compression of compressible content
+// types for files without precompressed siblings, using gzip or zstd.
+// Compressed content is cached in memory by the hashed path, so each file is
+// compressed only once per encoding. The cache is limited to 32 MiB, evicting
+// the least recently used content first, which WithCompressionCache changes.
+func WithCompression() Option {
+	return func(s *Server) {
+		s.compression = true
+	}
+}
+
+// defaultCompressionCache is the default size limit of the cache of content
+// compressed on the fly.
+const defaultCompressionCache = 32 << 20
+
+// WithCompressionCache limits the cache of content compressed on the fly by
+// WithCompression to maxBytes in total.
+func WithCompressionCache(maxBytes int64) Option {
+	return func(s *Server) {
+		s.compressedCache = newLRU[compressedKey](maxBytes)
+	}
+}
+
+// WithEncodings configures the content encodings used for precompressed
+// siblings and on the fly compression, in order of preference. The supported
+// encodings are "zstd", "br" and "gzip", which is also the default order.
+// Brotli is only used for precompressed siblings. CSS files whose references
+// and JavaScript files whose source map reference are rewritten to hashed paths
+// do not match their precompressed siblings, so they are compressed on the fly
+// with zstd or gzip instead, and served unencoded to clients which only accept
+// brotli. Unless no encodings are
+// given, every file response includes Vary: Accept-Encoding, and requests
+// refusing identity with none of the available encodings acceptable get a 406
+// Not Acceptable.
+func WithEncodings(encodings ...string) Option {
+	return func(s *Server) {
+		s.encodings = encodings
+	}
+}
+
+// siblingExts are the file extensions of precompressed siblings.
+var siblingExts = map[string]string{
+	"zstd": ".zst",
+	"br":   ".br",
+	"gzip": ".gz",
+}
+
+// encoders are the encodings supported for on the fly compression.
+var encoders = map[string]func(io.Writer) (io.WriteCloser, error){
+	"zstd": func(w io.Writer) (io.WriteCloser, error) {
+		return zstd.NewWriter(w)
+	},
+	"gzip": func(w io.Writer) (io.WriteCloser, error) {
+		return gzip.NewWriter(w), nil
+	},
+}
+
+// compressibleTypes are the media types compressed by WithCompression, in
+// addition to all text types.
+var compressibleTypes = []string{
+	"application/javascript",
+	"application/json",
+	"application/manifest+json",
+	"application/wasm",
+	"application/xml",
+	"image/svg+xml",
+}
+
+func isCompressible(ctype string) bool {
+	mediaType, _, _ := strings.Cut(ctype, ";")
+	mediaType = strings.TrimSpace(mediaType)
+	return strings.HasPrefix(mediaType, "text/") || slices.Contains(compressibleTypes, mediaType)
+}
+
+// siblingEncodings returns the encodings for which precompressed siblings of
+// filename exist.
+func (s *Server) siblingEncodings(filename string) []string {
+	cached, found := s.siblings.Load(filename)
+	if found {
+		return cached.([]string)
+	}
+	var encodings []string
+	for _, encoding := range s.encodings {
+		ext, ok := siblingExts[encoding]
+		if !ok {
+			continue
+		}
+		if _, err := fs.Stat(s.fs, filename+ext); err == nil {
+			encodings = append(encodings, encoding)
+		}
+	}
+	if !s.noCache {
+		s.siblings.Store(filename, encodings)
+	}
+	return encodings
+}
+
+// setEncoding sets the Content-Encoding header along with an ETag specific to
+// the encoding.
+func setEncoding(h http.Header, encoding string) {
+	h.Set("Content-Encoding", encoding)
+	if etag := h.Get("ETag"); etag != "" {
+		h.Set("ETag", strings.TrimSuffix(etag, `"`)+"-"+encoding+`"`)
+	}
+}
+
+// negotiateEncoding returns the precompressed sibling of filename to serve
+// based on the Accept-Encoding header, or an empty string to serve filename
+// itself. It sets the Content-Encoding, Content-Type and ETag headers as
+// necessary.
+func (s *Server) negotiateEncoding(w http.ResponseWriter, r *http.Request, filename string) string {
+	encodings := s.siblingEncodings(filename)
+	if len(encodings) == 0 {
+		return ""
+	}
+
+	// the type cannot be sniffed from compressed content
+	h := w.Header()
+	if h.Get("Content-Type") == "" {
+		ctype := s.contentType(filename)
+		if ctype == "" {
+			return ""
+		}
+		h.Set("Content-Type", ctype)
+	}
+
+	encoding := preferredEncoding(r.Header.Get("Accept-Encoding"), encodings)
+	if encoding == "" {
+		return ""
+	}
+	setEncoding(h, encoding)
+	return filename + siblingExts[encoding]
+}
+
+// serveCompressed serves filename with on the fly compression if the content
+// type is compressible and the client accepts it. It returns false if nothing
+// was served.
+func (s *Server) serveCompressed(w http.ResponseWriter, r *http.Request, filename string) bool {
+	h := w.Header()
+	ctype := h.Get("Content-Type")
+	if ctype == "" {
+		ctype = s.contentType(filename)
+	}
+	if !isCompressible(ctype) {
+		return false
+	}
+	var supported []string
+	for _, e := range s.encodings {
+		if encoders[e] != nil {
+			supported = append(supported, e)
+		}
+	}
+	encoding := preferredEncoding(r.Header.Get("Accept-Encoding"), supported)
+	if encoding == "" {
+		return false
+	}
+	e, err := s.hash(filename)
+	if err != nil {
+		return false
+	}
+	compressed, hit, err := s.compressed(e.path, filename, encoding)
+	if err != nil {
+		return false
+	}
+	if hit {
+		s.setCacheStatus(h, "hit; detail=compressed")
+	} else {
+		s.setCacheStatus(h, "fwd=miss; stored; detail=compressed")
+	}
+
+	h.Set("Content-Type", ctype)
+	setEncoding(h, encoding)
+	http.ServeContent(w, r, filename, s.lastModified(e.modTime), bytes.NewReader(compressed))
+	return true
+}
+
+// ErrNotAcceptable is returned when a request refuses the unencoded content of a
+// file and none of its available encodings, using 406 Not Acceptable by
+// default.
+var ErrNotAcceptable = errors.New("hashfs: no acceptable content encoding")
+
+// rejectIdentity serves ErrNotAcceptable if the request refuses the unencoded
+// content, after no acceptable encoding was found. It returns true if it
+// responded.
+func (s *Server) rejectIdentity(w http.ResponseWriter, r *http.Request) bool {
+	if len(s.encodings) == 0 || identityAcceptable(r.Header.Get("Accept-Encoding")) {
+		return false
+	}
+	// the error must not be cached like the file
+	h := w.Header()
+	h.Del("Cache-Control")
+	h.Del("ETag")
+	h.Del("Last-Modified")
+	s.serveError(w, r, fmt.Errorf("%w for %q", ErrNotAcceptable, r.URL.Path))
+	return true
+}
+
+// preferredEncoding returns the encoding the Accept-Encoding header value
+// prefers, by quality and then by the order of the encodings, or an empty
+// string to serve the content unencoded. A "*" matches encodings which are not
+// listed, and identity is only preferred over an encoding if it is listed with
+// a higher quality.
+func preferredEncoding(header string, encodings []string) string {
+	quality := encodingQualities(header)
+	var preferred string
+	var best float64
+	for _, encoding := range encodings {
+		if q, _ := quality(encoding); q > best {
+			preferred, best = encoding, q
+		}
+	}
+	if q, found := quality("identity"); found && q > best {
+		return ""
+	}
+	return preferred
+}
+
+// identityAcceptable reports if the Accept-Encoding header value allows the
+// content to be served unencoded, which it does unless identity, or "*"
+// without identity listed, is given a zero quality.
+func identityAcceptable(header string) bool {
+	q, found := encodingQualities(header)("identity")
+	return !found || q > 0
+}
+
+// encodingQualities parses the Accept-Encoding header value and returns a
+// function reporting the quality of an encoding, and whether it was listed
+// explicitly or through "*".
+func encodingQualities(header string) func(string) (float64, bool) {
+	qualities := make(map[string]float64)
+	for accepted := range strings.SplitSeq(header, ",") {
+		name, params, _ := strings.Cut(accepted, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		q := 1.0
+		for param := range strings.SplitSeq(params, ";") {
+			key, value, _ := strings.Cut(param, "=")
+			if strings.EqualFold(strings.TrimSpace(key), "q") {
+				var err error
+				if q, err = strconv.ParseFloat(strings.TrimSpace(value), 64); err != nil {
+					q = 0
+				}
+			}
+		}
+		qualities[name] = q
+	}
+	return func(encoding string) (float64, bool) {
+		if q, found := qualities[encoding]; found {
+			return q, true
+		}
+		q, found := qualities["*"]
+		return q, found
+	}
+}
+
+type compressedKey struct {
+	hashed, encoding string
+}
+
+// compressed returns the content of filename compressed with the encoding,
+// cached by its hashed path, and whether it was found in the cache.
+func (s *Server) compressed(hashed, filename, encoding string) ([]byte, bool, error) {
+	key := compressedKey{hashed: hashed, encoding: encoding}
+	if cached, found := s.compressedCache.get(key); found {
+		return cached, true, nil
+	}
+	r, err := s.content(filename)
+	if err != nil {
+		return nil, false, err
+	}
+	defer r.Close()
+	var buf bytes.Buffer
+	cw, err := encoders[encoding](&buf)
+	if err != nil {
+		return nil, false, fmt.Errorf("hashfs: error compressing file %q: %w", filename, err)
+	}
+	if _, err := io.Copy(cw, r); err != nil {
+		return nil, false, fmt.Errorf("hashfs: error compressing file %q: %w", filename, err)
+	}
+	if err := cw.Close(); err != nil {
+		return nil, false, fmt.Errorf("hashfs: error compressing file %q: %w", filename, err)
+	}
+	s.compressedCache.add(key, buf.Bytes())
+	return buf.Bytes(), false, nil
+}
@@ -0,0 +1,304 @@
+package hashfs
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/daaku/ensure"
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestPrecompressed(t *testing.T) {
+	fsys := fstest.MapFS{
+		"main.js":    {Data: []byte("main")},
+		"main.js.br": {Data: []byte("br")},
+		"main.js.gz": {Data: []byte("gz")},
+		"only.js":    {Data: []byte("only")},
+		"only.js.gz": {Data: []byte("only gz")},
+		"plain.js":   {Data: []byte("plain")},
+	}
+	s := New(fsys)
+	cases := []struct {
+		name, acceptEncoding, body, encoding, vary string
+	}{
+		{"main.js", "gzip, deflate, br", "br", "br", "Accept-Encoding"},
+		{"main.js", "gzip", "gz", "gzip", "Accept-Encoding"},
+		{"main.js", "br;q=0, gzip", "gz", "gzip", "Accept-Encoding"},
+		{"main.js", "", "main", "", "Accept-Encoding"},
+		{"only.js", "br, gzip", "only gz", "gzip", "Accept-Encoding"},
+		{"plain.js", "br, gzip", "plain", "", "Accept-Encoding"},
+	}
+	for _, c := range cases {
+		e, err := s.hash(c.name)
+		ensure.Nil(t, err)
+		r := httptest.NewRequest("GET", "/"+e.path, nil)
+		r.Header.Set("Accept-Encoding", c.acceptEncoding)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		ensure.DeepEqual(t, w.Code, http.StatusOK)
+		ensure.DeepEqual(t, w.Body.String(), c.body)
+		ensure.DeepEqual(t, w.Header().Get("Content-Encoding"), c.encoding)
+		ensure.DeepEqual(t, w.Header().Get("Content-Type"), "text/javascript; charset=utf-8")
+		ensure.DeepEqual(t, w.Header().Get("Vary"), c.vary)
+		if c.encoding != "" {
+			ensure.DeepEqual(t, w.Header().Get("ETag"), `"`+e.hash+"-"+c.encoding+`"`)
+		}
+	}
+}
+
+func TestCompression(t *testing.T) {
+	fsys := fstest.MapFS{
+		"main.js":   {Data: []byte(strings.Repeat("main", 100))},
+		"br.js":     {Data: []byte("br")},
+		"br.js.br":  {Data: []byte("compressed br")},
+		"photo.png": {Data: []byte("png")},
+		"main.css":  {Data: []byte(`@import "other.css";`)},
+		"other.css": {Data: []byte("other")},
+	}
+	s := New(fsys, WithCompression())
+	cases := []struct {
+		name, acceptEncoding, encoding, vary, body string
+	}{
+		{"main.js", "gzip", "gzip", "Accept-Encoding", strings.Repeat("main", 100)},
+		{"main.js", "br", "", "Accept-Encoding", strings.Repeat("main", 100)},
+		{"br.js", "br", "br", "Accept-Encoding", "compressed br"},
+		{"br.js", "gzip", "gzip", "Accept-Encoding", "br"},
+		{"photo.png", "gzip", "", "Accept-Encoding", "png"},
+		{"main.css", "gzip", "gzip", "Accept-Encoding", `@import "other.d9298a10d1b0.css";`},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest("GET", "/"+s.Path(c.name), nil)
+		r.Header.Set("Accept-Encoding", c.acceptEncoding)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		ensure.DeepEqual(t, w.Code, http.StatusOK)
+		ensure.DeepEqual(t, w.Header().Get("Content-Encoding"), c.encoding)
+		ensure.DeepEqual(t, strings.Join(w.Header().Values("Vary"), ", "), c.vary)
+		body := w.Body.Bytes()
+		if c.encoding == "gzip" {
+			zr, err := gzip.NewReader(w.Body)
+			ensure.Nil(t, err)
+			body, err = io.ReadAll(zr)
+			ensure.Nil(t, err)
+			ensure.True(t, strings.HasSuffix(w.Header().Get("ETag"), `-gzip"`))
+		}
+		ensure.DeepEqual(t, string(body), c.body)
+	}
+}
+
+func TestCompressionCache(t *testing.T) {
+	fsys := fstest.MapFS{}
+	for i := range 10 {
+		fsys[fmt.Sprintf("%d.js", i)] = &fstest.MapFile{Data: []byte(strings.Repeat(fmt.Sprint(i), 100))}
+	}
+	s := New(fsys, WithCompression(), WithCompressionCache(100))
+	for name := range fsys {
+		r := httptest.NewRequest("GET", "/"+s.Path(name), nil)
+		r.Header.Set("Accept-Encoding", "gzip")
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		ensure.DeepEqual(t, w.Header().Get("Content-Encoding"), "gzip")
+	}
+	ensure.True(t, s.compressedCache.size <= 100)
+	ensure.True(t, s.compressedCache.evictions > 0)
+}
+
+func TestCompressionRange(t *testing.T) {
+	fsys := fstest.MapFS{"main.js": {Data: []byte(strings.Repeat("main", 100))}}
+	s := New(fsys, WithCompression())
+	r := httptest.NewRequest("GET", "/"+s.Path("main.js"), nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	r.Header.Set("Range", "bytes=0-1")
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	ensure.DeepEqual(t, w.Code, http.StatusPartialContent)
+	ensure.DeepEqual(t, w.Body.Bytes(), []byte{0x1f, 0x8b})
+}
+
+func TestZstd(t *testing.T) {
+	fsys := fstest.MapFS{
+		"main.js":     {Data: []byte(strings.Repeat("main", 100))},
+		"pre.js":      {Data: []byte("pre")},
+		"pre.js.zst":  {Data: []byte("pre zst")},
+		"pre.js.br":   {Data: []byte("pre br")},
+		"pre.js.gz":   {Data: []byte("pre gz")},
+		"other.js":    {Data: []byte("other")},
+		"other.js.gz": {Data: []byte("other gz")},
+	}
+	cases := []struct {
+		opts                           []Option
+		name, acceptEncoding, encoding string
+	}{
+		{nil, "pre.js", "gzip, br, zstd", "zstd"},
+		{[]Option{WithEncodings("br", "zstd")}, "pre.js", "gzip, br, zstd", "br"},
+		{[]Option{WithEncodings("gzip")}, "pre.js", "br, zstd", ""},
+		{[]Option{WithCompression()}, "main.js", "gzip, zstd", "zstd"},
+		{[]Option{WithCompression(), WithEncodings("gzip", "zstd")}, "main.js", "gzip, zstd", "gzip"},
+		{[]Option{WithCompression()}, "other.js", "zstd", "zstd"},
+	}
+	for _, c := range cases {
+		s := New(fsys, c.opts...)
+		r := httptest.NewRequest("GET", "/"+s.Path(c.name), nil)
+		r.Header.Set("Accept-Encoding", c.acceptEncoding)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		ensure.DeepEqual(t, w.Code, http.StatusOK)
+		ensure.DeepEqual(t, w.Header().Get("Content-Encoding"), c.encoding)
+	}
+
+	s := New(fsys, WithCompression())
+	r := httptest.NewRequest("GET", "/"+s.Path("main.js"), nil)
+	r.Header.Set("Accept-Encoding", "zstd")
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	zr, err := zstd.NewReader(w.Body)
+	ensure.Nil(t, err)
+	defer zr.Close()
+	body, err := io.ReadAll(zr)
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, string(body), strings.Repeat("main", 100))
+}
+
+func TestPreferredEncoding(t *testing.T) {
+	encodings := []string{"zstd", "br", "gzip"}
+	cases := []struct {
+		header, encoding string
+	}{
+		{"", ""},
+		{"gzip, br, zstd", "zstd"},
+		{"GZIP", "gzip"},
+		{"gzip;q=0.5, br;q=0.8", "br"},
+		{"gzip; q=1.0, zstd;q=0.9", "gzip"},
+		{"zstd;q=0, gzip", "gzip"},
+		{"*", "zstd"},
+		{"*;q=0.5, br", "br"},
+		{"*, zstd;q=0", "br"},
+		{"gzip;q=0.5, identity", ""},
+		{"gzip;q=0.5, identity;q=0.5", "gzip"},
+		{"identity;q=0, gzip;q=0.1", "gzip"},
+		{"gzip;q=invalid", ""},
+		{"deflate", ""},
+	}
+	for _, c := range cases {
+		ensure.DeepEqual(t, preferredEncoding(c.header, encodings), c.encoding, c.header)
+	}
+}
+
+func TestPrecompressedCSS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"main.css":     {Data: []byte(`@import "other.css";`)},
+		"main.css.br":  {Data: []byte("stale br")},
+		"main.css.gz":  {Data: []byte("stale gz")},
+		"other.css":    {Data: []byte("other")},
+		"other.css.gz": {Data: []byte("other gz")},
+	}
+	s := New(fsys)
+	cases := []struct {
+		name, acceptEncoding, encoding, body string
+	}{
+		{"main.css", "br", "", `@import "other.d9298a10d1b0.css";`},
+		{"main.css", "br, gzip", "gzip", `@import "other.d9298a10d1b0.css";`},
+		{"other.css", "gzip", "gzip", "other gz"},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest("GET", "/"+s.Path(c.name), nil)
+		r.Header.Set("Accept-Encoding", c.acceptEncoding)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		ensure.DeepEqual(t, w.Code, http.StatusOK)
+		ensure.DeepEqual(t, w.Header().Get("Content-Encoding"), c.encoding)
+		body := w.Body.Bytes()
+		if c.name == "main.css" && c.encoding == "gzip" {
+			zr, err := gzip.NewReader(w.Body)
+			ensure.Nil(t, err)
+			body, err = io.ReadAll(zr)
+			ensure.Nil(t, err)
+		}
+		ensure.DeepEqual(t, string(body), c.body)
+	}
+}
+
+func TestNotAcceptable(t *testing.T) {
+	fsys := fstest.MapFS{
+		"main.js":    {Data: []byte("main")},
+		"main.js.gz": {Data: []byte("main gz")},
+		"photo.png":  {Data: []byte("png")},
+	}
+	s := New(fsys, WithCompression())
+	cases := []struct {
+		name, acceptEncoding string
+		code                 int
+	}{
+		{"main.js", "identity;q=0, gzip", http.StatusOK},
+		{"main.js", "identity;q=0, deflate", http.StatusNotAcceptable},
+		{"main.js", "*;q=0", http.StatusNotAcceptable},
+		{"main.js", "*;q=0, identity", http.StatusOK},
+		{"photo.png", "identity;q=0, gzip", http.StatusNotAcceptable},
+		{"photo.png", "gzip", http.StatusOK},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest("GET", "/"+s.Path(c.name), nil)
+		r.Header.Set("Accept-Encoding", c.acceptEncoding)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		ensure.DeepEqual(t, w.Code, c.code, c.name, c.acceptEncoding)
+		ensure.DeepEqual(t, w.Header().Get("Vary"), "Accept-Encoding")
+		if c.code == http.StatusNotAcceptable {
+			ensure.DeepEqual(t, w.Header().Get("Cache-Control"), "")
+			ensure.DeepEqual(t, w.Header().Get("ETag"), "")
+		}
+	}
+
+	var handled error
+	s = New(fsys, WithErrorHandler(func(w http.ResponseWriter, r *http.Request, err error) {
+		handled = err
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	r := httptest.NewRequest("GET", "/"+s.Path("main.js"), nil)
+	r.Header.Set("Accept-Encoding", "*;q=0")
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	ensure.DeepEqual(t, w.Code, http.StatusTeapot)
+	ensure.True(t, errors.Is(handled, ErrNotAcceptable))
+
+	s = New(fsys, WithEncodings())
+	r = httptest.NewRequest("GET", "/"+s.Path("main.js"), nil)
+	r.Header.Set("Accept-Encoding", "identity;q=0")
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	ensure.DeepEqual(t, w.Code, http.StatusOK)
+	ensure.DeepEqual(t, w.Header().Get("Vary"), "")
+}
+
+func TestPrecompressedQuality(t *testing.T) {
+	fsys := fstest.MapFS{
+		"pre.js":    {Data: []byte("pre")},
+		"pre.js.br": {Data: []byte("pre br")},
+		"pre.js.gz": {Data: []byte("pre gz")},
+	}
+	s := New(fsys)
+	cases := []struct {
+		acceptEncoding, encoding, body string
+	}{
+		{"br;q=0.2, gzip;q=0.8", "gzip", "pre gz"},
+		{"gzip;q=0, br;q=0", "", "pre"},
+		{"identity;q=1, br;q=0.5", "", "pre"},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest("GET", "/"+s.Path("pre.js"), nil)
+		r.Header.Set("Accept-Encoding", c.acceptEncoding)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		ensure.DeepEqual(t, w.Code, http.StatusOK)
+		ensure.DeepEqual(t, w.Header().Get("Content-Encoding"), c.encoding)
+		ensure.DeepEqual(t, w.Header().Get("Vary"), "Accept-Encoding")
+		ensure.DeepEqual(t, w.Body.String(), c.body)
+	}
+}
@@ -0,0 +1,151 @@
+package hashfs
+
+import (
+	"bytes"
+	"container/list"
+	"io"
+	"net/http"
+	"sync"
+)
+
+// WithContentCache enables an in-memory cache of served file contents, limited
+// to maxBytes in total and evicting the least recently used files first. It is
+// useful for slow file systems, such as zip or network backed ones. Entries are
+// keyed by content hash, so changed files never serve stale content. Range and
+// If-Range requests are served from the cached content.
+func WithContentCache(maxBytes int64) Option {
+	return func(s *Server) {
+		s.contentCache = newLRU[contentKey](maxBytes)
+	}
+}
+
+// WithContentCacheMaxFile limits the content cache to files no larger than
+// maxBytes, streaming larger files from the file system instead. This keeps a
+// few large files from evicting many small frequently used ones.
+func WithContentCacheMaxFile(maxBytes int64) Option {
+	return func(s *Server) {
+		s.contentCacheMaxFile = maxBytes
+	}
+}
+
+// contentKey identifies the served file, which may be a sibling variant of the
+// requested file, along with its own content hash, since a variant can change
+// while the requested file does not.
+type contentKey struct {
+	hash, served string
+}
+
+// serveCached serves the content of served from the content cache, reading it
+// into the cache if necessary. The detail is added to the Cache-Status. It
+// returns false if nothing was served.
+func (s *Server) serveCached(w http.ResponseWriter, r *http.Request, served, detail string) bool {
+	e, err := s.hash(served)
+	if err != nil {
+		return false
+	}
+	key := contentKey{hash: e.hash, served: served}
+	content, found := s.contentCache.get(key)
+	s.observeCache("content", found)
+	if !found {
+		f, err := s.fs.Open(served)
+		if err != nil {
+			return false
+		}
+		defer f.Close()
+		fi, err := f.Stat()
+		if err != nil || fi.IsDir() {
+			return false
+		}
+		if s.contentCacheMaxFile > 0 && fi.Size() > s.contentCacheMaxFile {
+			return false
+		}
+		content, err = io.ReadAll(f)
+		if err != nil {
+			return false
+		}
+		s.contentCache.add(key, content)
+		s.setCacheStatus(w.Header(), "fwd=miss; stored"+detail)
+	} else {
+		s.setCacheStatus(w.Header(), "hit"+detail)
+	}
+	http.ServeContent(w, r, served, s.lastModified(e.modTime), bytes.NewReader(content))
+	return true
+}
+
+// lru is a byte size limited cache that evicts the least recently used
+// entries first.
+type lru[K comparable] struct {
+	mu        sync.Mutex
+	max       int64
+	size      int64
+	evictions int64
+	ll        *list.List
+	items     map[K]*list.Element
+}
+
+type lruEntry[K comparable] struct {
+	key   K
+	value []byte
+}
+
+func newLRU[K comparable](max int64) *lru[K] {
+	return &lru[K]{
+		max:   max,
+		ll:    list.New(),
+		items: make(map[K]*list.Element),
+	}
+}
+
+func (c *lru[K]) get(key K) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, found := c.items[key]
+	if !found {
+		return nil, false
+	}
+	c.ll.MoveToFront(el)
+	return el.Value.(*lruEntry[K]).value, true
+}
+
+func (c *lru[K]) add(key K, value []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if int64(len(value)) > c.max {
+		return
+	}
+	if el, found := c.items[key]; found {
+		c.ll.MoveToFront(el)
+		return
+	}
+	c.items[key] = c.ll.PushFront(&lruEntry[K]{key: key, value: value})
+	c.size += int64(len(value))
+	for c.size > c.max {
+		el := c.ll.Back()
+		e := el.Value.(*lruEntry[K])
+		c.ll.Remove(el)
+		delete(c.items, e.key)
+		c.size -= int64(len(e.value))
+		c.evictions++
+	}
+}
+
+func (c *lru[K]) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ll.Init()
+	clear(c.items)
+	c.size = 0
+}
+
+// deleteFunc removes the entries with keys for which del returns true.
+func (c *lru[K]) deleteFunc(del func(K) bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, el := range c.items {
+		if del(key) {
+			c.ll.Remove(el)
+			delete(c.items, key)
+			c.size -= int64(len(el.Value.(*lruEntry[K]).value))
+		}
+	}
+}
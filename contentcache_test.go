@@ -0,0 +1,125 @@
+package hashfs
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+
+	"github.com/daaku/ensure"
+)
+
+func TestContentCache(t *testing.T) {
+	fsys := &countingFS{FS: fstest.MapFS{"main.txt": {Data: []byte("main")}}}
+	s := New(fsys, WithContentCache(1024))
+	hashed := s.Path("main.txt")
+	var opens int64
+	cases := []struct {
+		header, value string
+		code          int
+		body          string
+	}{
+		{"", "", http.StatusOK, "main"},
+		{"Range", "bytes=1-2", http.StatusPartialContent, "ai"},
+		{"If-None-Match", `"0d6e4079e367"`, http.StatusNotModified, ""},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest("GET", "/"+hashed, nil)
+		if c.header != "" {
+			r.Header.Set(c.header, c.value)
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		ensure.DeepEqual(t, w.Code, c.code)
+		ensure.DeepEqual(t, w.Body.String(), c.body)
+		if opens == 0 {
+			opens = fsys.opens.Load()
+		}
+	}
+	ensure.DeepEqual(t, fsys.opens.Load(), opens)
+}
+
+func TestLRU(t *testing.T) {
+	c := newLRU[contentKey](6)
+	a, b, d := contentKey{served: "a"}, contentKey{served: "b"}, contentKey{served: "d"}
+	c.add(a, []byte("aa"))
+	c.add(b, []byte("bb"))
+	_, found := c.get(a)
+	ensure.True(t, found)
+	c.add(d, []byte("ddd"))
+	_, found = c.get(b)
+	ensure.False(t, found)
+	_, found = c.get(a)
+	ensure.True(t, found)
+	c.add(contentKey{served: "big"}, []byte("toolarge"))
+	_, found = c.get(d)
+	ensure.True(t, found)
+}
+
+func TestContentCacheMaxFile(t *testing.T) {
+	fsys := fstest.MapFS{
+		"small.txt": {Data: []byte("small")},
+		"large.txt": {Data: []byte("large content")},
+	}
+	s := New(fsys, WithContentCache(1024), WithContentCacheMaxFile(8))
+	for _, filename := range []string{"small.txt", "large.txt"} {
+		r := httptest.NewRequest("GET", "/"+s.Path(filename), nil)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		ensure.DeepEqual(t, w.Code, http.StatusOK)
+	}
+	ensure.DeepEqual(t, s.contentCache.ll.Len(), 1)
+	ensure.DeepEqual(t, s.contentCache.ll.Front().Value.(*lruEntry[contentKey]).key.served, "small.txt")
+}
+
+func TestCacheStatus(t *testing.T) {
+	fsys := fstest.MapFS{
+		"main.txt":   {Data: []byte("main")},
+		"main.css":   {Data: []byte(`@import "main.txt";`)},
+		"app.js":     {Data: []byte("app")},
+		"app.js.gz":  {Data: []byte("gz")},
+		"other.json": {Data: []byte("{}")},
+	}
+	cases := []struct {
+		opts           []Option
+		filename       string
+		acceptEncoding string
+		statuses       []string
+	}{
+		{nil, "main.txt", "", []string{"hashfs; fwd=miss", "hashfs; fwd=miss"}},
+		{[]Option{WithContentCache(1024)}, "main.txt", "", []string{"hashfs; fwd=miss; stored", "hashfs; hit"}},
+		{nil, "main.css", "", []string{"hashfs; hit; detail=rewritten"}},
+		{nil, "app.js", "gzip", []string{"hashfs; fwd=miss; detail=precompressed"}},
+		{[]Option{WithCompression()}, "other.json", "gzip", []string{"hashfs; fwd=miss; stored; detail=compressed", "hashfs; hit; detail=compressed"}},
+	}
+	for _, c := range cases {
+		s := New(fsys, append(c.opts, WithCacheStatus())...)
+		for _, status := range c.statuses {
+			r := httptest.NewRequest("GET", "/"+s.Path(c.filename), nil)
+			r.Header.Set("Accept-Encoding", c.acceptEncoding)
+			w := httptest.NewRecorder()
+			s.ServeHTTP(w, r)
+			ensure.DeepEqual(t, w.Code, http.StatusOK)
+			ensure.DeepEqual(t, w.Header().Get("Cache-Status"), status)
+		}
+	}
+}
+
+func TestContentCacheVariant(t *testing.T) {
+	fsys := fstest.MapFS{
+		"main.js":    {Data: []byte("main")},
+		"main.js.br": {Data: []byte("br")},
+	}
+	s := New(fsys, WithContentCache(1024))
+	for _, body := range []string{"br", "changed"} {
+		fsys["main.js.br"] = &fstest.MapFile{Data: []byte(body)}
+		// as done by the watch package when the sibling changes
+		s.Invalidate("main.js.br")
+		r := httptest.NewRequest("GET", "/"+s.Path("main.js"), nil)
+		r.Header.Set("Accept-Encoding", "br")
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		ensure.DeepEqual(t, w.Header().Get("Content-Encoding"), "br")
+		ensure.DeepEqual(t, w.Body.String(), body)
+	}
+}
@@ -0,0 +1,66 @@
+package hashfs
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// WithCORS enables cross-origin requests for files matching the patterns, or
+// all files if there are none, such as fonts and module scripts served from an
+// asset domain. The origins are matched exactly, except "*" which allows any
+// origin without credentials. Responses to allowed origins include the
+// Access-Control-Allow-Origin header, and Vary: Origin is sent whenever the
+// response depends on the request origin. Preflight requests are answered for
+// GET and HEAD. Patterns use the same syntax as WithPassthrough.
+func WithCORS(origins []string, patterns ...string) Option {
+	return func(s *Server) {
+		s.cors = &cors{origins: origins, patterns: patterns}
+	}
+}
+
+type cors struct {
+	origins  []string
+	patterns []string
+}
+
+// allowOrigin returns the Access-Control-Allow-Origin header value for the
+// request origin, or an empty string if it is not allowed.
+func (c *cors) allowOrigin(origin string) string {
+	if slices.Contains(c.origins, "*") {
+		return "*"
+	}
+	if origin != "" && slices.Contains(c.origins, origin) {
+		return origin
+	}
+	return ""
+}
+
+// setCORS sets the CORS headers for filename. It returns true if the request
+// was a preflight request, which has been answered.
+func (s *Server) setCORS(w http.ResponseWriter, r *http.Request, filename string) bool {
+	if s.cors == nil || (len(s.cors.patterns) > 0 && !matchAny(s.cors.patterns, filename)) {
+		return false
+	}
+	h := w.Header()
+	allowed := s.cors.allowOrigin(r.Header.Get("Origin"))
+	if allowed != "*" {
+		addVary(h, "Origin")
+	}
+	if allowed == "" {
+		return false
+	}
+	h.Set("Access-Control-Allow-Origin", allowed)
+	if r.Method != http.MethodOptions || r.Header.Get("Access-Control-Request-Method") == "" {
+		return false
+	}
+	switch strings.ToUpper(r.Header.Get("Access-Control-Request-Method")) {
+	case http.MethodGet, http.MethodHead:
+		h.Set("Access-Control-Allow-Methods", "GET, HEAD")
+		if headers := r.Header.Get("Access-Control-Request-Headers"); headers != "" {
+			h.Set("Access-Control-Allow-Headers", headers)
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return true
+}
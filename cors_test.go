@@ -0,0 +1,63 @@
+package hashfs
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+
+	"github.com/daaku/ensure"
+)
+
+func TestCORS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"font.woff2": {Data: []byte("font")},
+		"main.txt":   {Data: []byte("main")},
+	}
+	s := New(fsys, WithCORS([]string{"https://example.com"}, "*.woff2"))
+	cases := []struct {
+		name, origin, allow, vary string
+	}{
+		{"font.woff2", "https://example.com", "https://example.com", "Origin"},
+		{"font.woff2", "https://evil.com", "", "Origin"},
+		{"font.woff2", "", "", "Origin"},
+		{"main.txt", "https://example.com", "", "Accept-Encoding"},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest("GET", "/"+s.Path(c.name), nil)
+		if c.origin != "" {
+			r.Header.Set("Origin", c.origin)
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		ensure.DeepEqual(t, w.Code, http.StatusOK)
+		ensure.DeepEqual(t, w.Header().Get("Access-Control-Allow-Origin"), c.allow)
+		ensure.DeepEqual(t, w.Header().Get("Vary"), c.vary)
+	}
+}
+
+func TestCORSAnyOrigin(t *testing.T) {
+	s := New(fstest.MapFS{"main.js": {Data: []byte("main")}}, WithCORS([]string{"*"}))
+	r := httptest.NewRequest("GET", "/"+s.Path("main.js"), nil)
+	r.Header.Set("Origin", "https://example.com")
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	ensure.DeepEqual(t, w.Code, http.StatusOK)
+	ensure.DeepEqual(t, w.Header().Get("Access-Control-Allow-Origin"), "*")
+	ensure.DeepEqual(t, w.Header().Get("Vary"), "Accept-Encoding")
+}
+
+func TestCORSPreflight(t *testing.T) {
+	s := New(fstest.MapFS{"main.js": {Data: []byte("main")}}, WithCORS([]string{"https://example.com"}))
+	r := httptest.NewRequest("OPTIONS", "/"+s.Path("main.js"), nil)
+	r.Header.Set("Origin", "https://example.com")
+	r.Header.Set("Access-Control-Request-Method", "GET")
+	r.Header.Set("Access-Control-Request-Headers", "range")
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	ensure.DeepEqual(t, w.Code, http.StatusNoContent)
+	ensure.DeepEqual(t, w.Header().Get("Access-Control-Allow-Origin"), "https://example.com")
+	ensure.DeepEqual(t, w.Header().Get("Access-Control-Allow-Methods"), "GET, HEAD")
+	ensure.DeepEqual(t, w.Header().Get("Access-Control-Allow-Headers"), "range")
+	ensure.DeepEqual(t, w.Body.Len(), 0)
+}
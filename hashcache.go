@@ -0,0 +1,157 @@
+package hashfs
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sync"
+	"time"
+)
+
+// hashCacheVersion is the version of the hash cache file format, which is
+// ignored if it does not match.
+const hashCacheVersion = 1
+
+// WithHashCacheFile persists hashes to the file at path, so restarts do not
+// hash unchanged files again, which is useful for large disk backed trees.
+// Entries are keyed by the filename, size and modification time of files. The
+// file is ignored if it was written with a different format version or hash
+// function. The file is written by SaveHashCache, and after Precompute or Warm
+// finish.
+//
+// Only hashes which depend on nothing but the file are persisted. CSS files
+// with relative references, JavaScript files with a relative source map
+// reference and images with variants for WithImageNegotiation also depend on
+// other files, which their size and modification time do not capture, so they
+// are hashed on every start. CSS and JavaScript files without such references
+// are persisted like any other file. Files without a modification time, such
+// as those in an embed.FS, cannot be checked for changes and are not persisted.
+func WithHashCacheFile(path string) Option {
+	return func(s *Server) {
+		s.hashCache = &hashCache{path: path}
+	}
+}
+
+type hashCache struct {
+	path    string
+	once    sync.Once
+	mu      sync.Mutex
+	check   string
+	entries map[string]hashCacheEntry
+	dirty   bool
+}
+
+// hashCacheFile is the format of the hash cache file.
+type hashCacheFile struct {
+	Version int                       `json:"version"`
+	Check   string                    `json:"check"` // identifies the hash function
+	Entries map[string]hashCacheEntry `json:"entries"`
+}
+
+type hashCacheEntry struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+	Sum     []byte    `json:"sum"`
+}
+
+// load reads the hash cache file once, ignoring it if it is missing or
+// incompatible.
+func (c *hashCache) load(s *Server) {
+	c.once.Do(func() {
+		c.check = s.hashCheck()
+		c.entries = make(map[string]hashCacheEntry)
+
+		b, err := os.ReadFile(c.path)
+		if err != nil {
+			return
+		}
+		var f hashCacheFile
+		if err := json.Unmarshal(b, &f); err != nil {
+			return
+		}
+		if f.Version == hashCacheVersion && f.Check == c.check && f.Entries != nil {
+			c.entries = f.Entries
+		}
+	})
+}
+
+// hashCheck identifies the hash function, so hashes computed with another are
+// not used.
+func (s *Server) hashCheck() string {
+	h := s.newHash()
+	h.Write([]byte("hashfs"))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// lookupHashCache returns the cached hash for filename if its size and modification
+// time are unchanged.
+func (s *Server) lookupHashCache(filename string) (*hashEntry, bool) {
+	if s.hasImageVariants(filename) {
+		return nil, false
+	}
+	c := s.hashCache
+	c.load(s)
+	c.mu.Lock()
+	ce, found := c.entries[filename]
+	c.mu.Unlock()
+	if !found {
+		return nil, false
+	}
+	fi, err := fs.Stat(s.fs, filename)
+	if err != nil || fi.Size() != ce.Size || !fi.ModTime().Equal(ce.ModTime) {
+		return nil, false
+	}
+	e := s.entry(filename, s.digest(ce.Sum))
+	e.modTime, e.size, e.checked = ce.ModTime, ce.Size, time.Now()
+	return e, true
+}
+
+// storeHashCache records the hash of filename to be persisted.
+func (s *Server) storeHashCache(filename string, e *hashEntry) {
+	if e.derived || e.modTime.IsZero() {
+		return
+	}
+	c := s.hashCache
+	c.load(s)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[filename] = hashCacheEntry{Size: e.size, ModTime: e.modTime, Sum: e.digest}
+	c.dirty = true
+}
+
+// SaveHashCache writes the hashes of files to the file configured by
+// WithHashCacheFile, if they changed since it was read or last written.
+func (s *Server) SaveHashCache() error {
+	c := s.hashCache
+	if c == nil {
+		return errors.New("hashfs: hash cache file not configured")
+	}
+	c.load(s)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.dirty {
+		return nil
+	}
+	b, err := json.Marshal(hashCacheFile{
+		Version: hashCacheVersion,
+		Check:   c.check,
+		Entries: c.entries,
+	})
+	if err != nil {
+		return fmt.Errorf("hashfs: error encoding hash cache: %w", err)
+	}
+	// written to a temporary file first, so a crash never leaves a partial
+	// cache file
+	tmp := c.path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return fmt.Errorf("hashfs: error writing hash cache: %w", err)
+	}
+	if err := os.Rename(tmp, c.path); err != nil {
+		return fmt.Errorf("hashfs: error writing hash cache: %w", err)
+	}
+	c.dirty = false
+	return nil
+}
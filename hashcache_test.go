@@ -0,0 +1,99 @@
+package hashfs
+
+import (
+	"crypto/sha1"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/daaku/ensure"
+)
+
+func TestHashCacheFile(t *testing.T) {
+	dir, cacheFile := t.TempDir(), filepath.Join(t.TempDir(), "hashes.json")
+	name := filepath.Join(dir, "a.txt")
+	modTime := time.Unix(1700000000, 0)
+	ensure.Nil(t, os.WriteFile(name, []byte("aaaa"), 0o644))
+	ensure.Nil(t, os.Chtimes(name, modTime, modTime))
+
+	s := New(os.DirFS(dir), WithHashCacheFile(cacheFile))
+	ensure.Nil(t, s.Precompute())
+	a := s.Path("a.txt")
+
+	// the size and modification time are unchanged, so the cached hash is used
+	ensure.Nil(t, os.WriteFile(name, []byte("bbbb"), 0o644))
+	ensure.Nil(t, os.Chtimes(name, modTime, modTime))
+	ensure.DeepEqual(t, New(os.DirFS(dir), WithHashCacheFile(cacheFile)).Path("a.txt"), a)
+
+	// a different hash function ignores the file
+	sha1Server := New(os.DirFS(dir), WithHashCacheFile(cacheFile), WithHash(sha1.New))
+	ensure.NotDeepEqual(t, sha1Server.Path("a.txt"), a)
+
+	ensure.Nil(t, os.Chtimes(name, modTime, modTime.Add(time.Second)))
+	s = New(os.DirFS(dir), WithHashCacheFile(cacheFile))
+	b := s.Path("a.txt")
+	ensure.NotDeepEqual(t, b, a)
+	ensure.Nil(t, s.SaveHashCache())
+	ensure.DeepEqual(t, New(os.DirFS(dir), WithHashCacheFile(cacheFile)).Path("a.txt"), b)
+
+	// an incompatible version ignores the file
+	data, err := os.ReadFile(cacheFile)
+	ensure.Nil(t, err)
+	data = []byte(strings.Replace(string(data), `"version":1`, `"version":0`, 1))
+	ensure.Nil(t, os.WriteFile(cacheFile, data, 0o644))
+	ensure.Nil(t, os.WriteFile(name, []byte("cccc"), 0o644))
+	ensure.Nil(t, os.Chtimes(name, modTime, modTime.Add(time.Second)))
+	ensure.NotDeepEqual(t, New(os.DirFS(dir), WithHashCacheFile(cacheFile)).Path("a.txt"), b)
+}
+
+func TestHashCacheFileSkipsRewritten(t *testing.T) {
+	dir, cacheFile := t.TempDir(), filepath.Join(t.TempDir(), "hashes.json")
+	modTime := time.Unix(1700000000, 0)
+	for name, data := range map[string]string{
+		"main.css":  `@import "other.css";`,
+		"other.css": "other",
+	} {
+		ensure.Nil(t, os.WriteFile(filepath.Join(dir, name), []byte(data), 0o644))
+		ensure.Nil(t, os.Chtimes(filepath.Join(dir, name), modTime, modTime))
+	}
+	s := New(os.DirFS(dir), WithHashCacheFile(cacheFile))
+	ensure.Nil(t, s.Precompute())
+	hashed := s.Path("main.css")
+
+	// the references change the rewritten content, so it is always hashed
+	other := filepath.Join(dir, "other.css")
+	ensure.Nil(t, os.WriteFile(other, []byte("OTHER"), 0o644))
+	ensure.Nil(t, os.Chtimes(other, modTime, modTime.Add(time.Second)))
+	ensure.NotDeepEqual(t, New(os.DirFS(dir), WithHashCacheFile(cacheFile)).Path("main.css"), hashed)
+}
+
+func TestHashCacheFileWithoutReferences(t *testing.T) {
+	dir, cacheFile := t.TempDir(), filepath.Join(t.TempDir(), "hashes.json")
+	modTime := time.Unix(1700000000, 0)
+	write := func(name, data string) {
+		ensure.Nil(t, os.WriteFile(filepath.Join(dir, name), []byte(data), 0o644))
+		ensure.Nil(t, os.Chtimes(filepath.Join(dir, name), modTime, modTime))
+	}
+	write("plain.css", "a {}")
+	write("app.js", "app")
+	write("missing.css", `@import "gone.css";`)
+	s := New(os.DirFS(dir), WithHashCacheFile(cacheFile))
+	ensure.Nil(t, s.Precompute())
+	plain, app, missing := s.Path("plain.css"), s.Path("app.js"), s.Path("missing.css")
+
+	// files without references are persisted, while a reference to a missing
+	// file may resolve later
+	write("plain.css", "b {}")
+	write("app.js", "APP")
+	write("missing.css", `@import "GONE.css";`)
+	s = New(os.DirFS(dir), WithHashCacheFile(cacheFile))
+	ensure.DeepEqual(t, s.Path("plain.css"), plain)
+	ensure.DeepEqual(t, s.Path("app.js"), app)
+	ensure.NotDeepEqual(t, s.Path("missing.css"), missing)
+}
+
+func TestSaveHashCacheNotConfigured(t *testing.T) {
+	ensure.NotNil(t, New(assets).SaveHashCache())
+}
@@ -3,95 +3,1274 @@ package hashfs
 
 import (
 	"bytes"
+	"context"
+	"crypto"
 	"crypto/sha256"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"hash"
 	"io"
 	"io/fs"
+	"mime"
 	"net/http"
+	"net/url"
 	"path"
 	"path/filepath"
+	"reflect"
 	"slices"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/tdewolff/parse/v2"
 	"github.com/tdewolff/parse/v2/css"
 )
 
-type cacheKey struct {
-	fs       fs.FS
-	filename string
+// Encoding converts the digest into the hash segment embedded in paths.
+type Encoding interface {
+	EncodeToString(src []byte) string
+}
+
+type hexEncoding struct{}
+
+func (hexEncoding) EncodeToString(src []byte) string {
+	return hex.EncodeToString(src)
 }
 
 var (
-	cachedHashes sync.Map
-	cachedCSS    sync.Map
+	// Hex encodes the hash as lowercase hexadecimal. This is the default.
+	Hex Encoding = hexEncoding{}
+
+	// Base32 encodes the hash as unpadded uppercase RFC 4648 base32. It is
+	// shorter than Hex, and Unhashed matches it and the file name
+	// case-insensitively which makes it suitable for systems that change the
+	// case of paths.
+	Base32 Encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+	// Base64URL encodes the hash as unpadded RFC 4648 URL safe base64.
+	Base64URL Encoding = base64.RawURLEncoding
 )
 
-func setImmutable(h http.Header) {
-	h.Set("cache-control", "public, immutable, max-age=31557600")
+// Validators selects the validators sent with responses and honored for
+// conditional requests.
+type Validators int
+
+const (
+	// ValidateBoth sends both an ETag and Last-Modified. As required by RFC
+	// 9110, If-None-Match takes precedence over If-Modified-Since. This is the
+	// default.
+	ValidateBoth Validators = iota
+
+	// ValidateETag only sends an ETag.
+	ValidateETag
+
+	// ValidateLastModified only sends Last-Modified.
+	ValidateLastModified
+)
+
+// Option configures a Server.
+type Option func(*Server)
+
+// WithEncoding configures the Encoding used for the hash in paths.
+func WithEncoding(e Encoding) Option {
+	return func(s *Server) {
+		s.encoding = e
+	}
+}
+
+// WithAccessRecorder configures a function called with the unhashed filename
+// for every request that resolves to a file. Accumulating these allows finding
+// assets that are never requested.
+func WithAccessRecorder(f func(unhashedName string)) Option {
+	return func(s *Server) {
+		s.accessRecorder = f
+	}
+}
+
+// WithImageNegotiation enables serving sibling variants of images in the given
+// content types to clients which accept them. For example, with "image/webp" a
+// request for photo.png will serve photo.webp if it exists and the Accept
+// header includes image/webp. Since the same URL then has several
+// representations, such responses include Vary: Accept and a weak ETag
+// specific to the representation, and the hash of an image covers its
+// variants, so the URL changes with any of them.
+func WithImageNegotiation(types ...string) Option {
+	return func(s *Server) {
+		s.imageTypes = append(s.imageTypes, types...)
+	}
+}
+
+// WithDebugHeaders enables the X-Hashfs-File response header containing the
+// unhashed filename a request resolved to. It is intended as a development aid
+// and leaks file names, so it should not be enabled in production.
+func WithDebugHeaders() Option {
+	return func(s *Server) {
+		s.debugHeaders = true
+	}
+}
+
+// WithContentTypeFunc configures a function to determine the Content-Type
+// served for a filename. A non-empty return value overrides the detected type,
+// while an empty one falls back to detection by extension and content.
+func WithContentTypeFunc(f func(name string) string) Option {
+	return func(s *Server) {
+		s.contentTypeFunc = f
+	}
+}
+
+// WithContentTypes configures the Content-Type served for file extensions,
+// such as ".webmanifest", overriding the mime package without changing its
+// global state. Extensions include the leading dot and are matched case
+// insensitively.
+func WithContentTypes(types map[string]string) Option {
+	return func(s *Server) {
+		if s.contentTypes == nil {
+			s.contentTypes = make(map[string]string, len(types))
+		}
+		for ext, ctype := range types {
+			s.contentTypes[strings.ToLower(ext)] = ctype
+		}
+	}
+}
+
+// WithModTime configures the Last-Modified time of all files, instead of the
+// modification time reported by the file system. This is useful with embed.FS,
+// which reports none, using the build time. Clients which ignore ETags can
+// then revalidate using If-Modified-Since.
+func WithModTime(t time.Time) Option {
+	return func(s *Server) {
+		s.modTime = t
+	}
+}
+
+// WithValidators configures the validators sent with responses and honored
+// for conditional requests, for CDNs which misbehave when both are present.
+func WithValidators(v Validators) Option {
+	return func(s *Server) {
+		s.validators = v
+	}
+}
+
+// WithCharset configures the charset parameter of the Content-Type served for
+// text, such as CSS, JavaScript, JSON and SVG files, for example "utf-8". An
+// existing charset from the mime package or other options is replaced.
+func WithCharset(charset string) Option {
+	return func(s *Server) {
+		s.charset = charset
+	}
+}
+
+// WithUnknownContentType configures a function to determine the Content-Type
+// of files with an extension unknown to WithContentTypes and the mime package,
+// including files without an extension. An empty return value falls back to
+// detection from the content.
+func WithUnknownContentType(f func(name string) string) Option {
+	return func(s *Server) {
+		s.unknownContentType = f
+	}
+}
+
+// WithMaxAge configures the max-age of the immutable Cache-Control header sent
+// with hashed responses. The default is one year.
+func WithMaxAge(d time.Duration) Option {
+	return func(s *Server) {
+		s.maxAge = d
+	}
+}
+
+// CompoundExtensions is a list of common multi-part extensions for use with
+// WithCompoundExtensions.
+var CompoundExtensions = []string{".min.js", ".min.mjs", ".min.css", ".js.map", ".css.map", ".d.ts", ".tar.gz", ".tar.bz2", ".tar.xz", ".tar.zst"}
+
+// WithCompoundExtensions configures multi-part extensions the hash is placed
+// before, for example app.<hash>.min.js instead of app.min.<hash>.js.
+// CompoundExtensions is a list of common ones.
+func WithCompoundExtensions(exts ...string) Option {
+	return func(s *Server) {
+		s.compoundExts = exts
+	}
+}
+
+// WithHashConcurrency limits the number of files hashed at the same time to n,
+// with other callers waiting their turn. This keeps a burst of first requests
+// after a cold start from saturating the disk or CPU.
+func WithHashConcurrency(n int) Option {
+	return func(s *Server) {
+		s.hashLimit = make(chan struct{}, n)
+	}
+}
+
+// WithNegativeCache caches files that were not found for the duration, so
+// repeated requests for missing files do not hit the file system. This is
+// useful for slow file systems, but files added within the duration of a
+// request for them will not be found until it expires or Invalidate is called.
+func WithNegativeCache(ttl time.Duration) Option {
+	return func(s *Server) {
+		s.missingTTL = ttl
+	}
+}
+
+// WithRevalidate re-verifies cached hashes once they are older than the
+// interval, for file systems which change while serving, such as os.DirFS or
+// network file systems. Files are re-hashed if their size or modification time
+// changed, or if the file system does not report a modification time. CSS and
+// JavaScript files with relative references and images with variants are
+// always re-hashed, as their hash depends on other files. Hashes loaded from a
+// manifest are not revalidated.
+func WithRevalidate(interval time.Duration) Option {
+	return func(s *Server) {
+		s.revalidate = interval
+	}
+}
+
+// WithNoCache disables caching hashes, so every call to Path or Unhashed and
+// every request hashes the file again, for file systems which change
+// constantly or in tests. Hashes loaded from a manifest are still used.
+func WithNoCache() Option {
+	return func(s *Server) {
+		s.noCache = true
+	}
+}
+
+// WithBaseURL configures a prefix for the paths returned by Path and
+// MaybePath, for example "https://cdn.example.com/static/". It does not affect
+// the paths accepted when serving requests.
+func WithBaseURL(base string) Option {
+	return func(s *Server) {
+		s.baseURL = base
+	}
+}
+
+// WithPrefix configures the URL prefix the Server is mounted at, for example
+// "/static/", where the trailing slash is optional. Requests are served without
+// wrapping the Server in http.StripPrefix, and requests outside the prefix are
+// not found. The paths returned by Path and MaybePath include the prefix,
+// unless a base URL is configured.
+func WithPrefix(prefix string) Option {
+	return func(s *Server) {
+		if prefix != "" && !strings.HasSuffix(prefix, "/") {
+			prefix += "/"
+		}
+		s.prefix = prefix
+	}
+}
+
+// WithDevMode disables hashing for local development. Path returns filenames
+// unchanged, requests are served using their plain paths and responses are sent
+// with Cache-Control: no-store.
+func WithDevMode() Option {
+	return func(s *Server) {
+		s.dev = true
+	}
+}
+
+// WithRedirect enables redirecting requests for unhashed filenames to their
+// current hashed path using the code, such as http.StatusMovedPermanently or
+// http.StatusPermanentRedirect. Since the target changes along with the
+// content, redirects are sent with Cache-Control: no-cache.
+func WithRedirect(code int) Option {
+	return func(s *Server) {
+		s.redirectCode = code
+	}
+}
+
+// WithUnhashed enables serving files requested using their unhashed paths,
+// with Cache-Control: no-cache instead of immutable caching. This keeps files
+// which are referenced by third parties reachable at stable paths.
+func WithUnhashed() Option {
+	return func(s *Server) {
+		s.serveUnhashed = true
+	}
+}
+
+// WithErrorHandler configures the handler used to respond to requests which
+// fail, such as for missing files or outdated hashes. Errors for missing files
+// wrap fs.ErrNotExist, and for outdated hashes ErrPathMismatch. The default
+// responds with the status text, using 404 Not Found for missing files, 410
+// Gone for outdated hashes and 400 Bad Request otherwise.
+func WithErrorHandler(h func(w http.ResponseWriter, r *http.Request, err error)) Option {
+	return func(s *Server) {
+		s.errorHandler = h
+	}
+}
+
+// WithStaleStatus configures the status code of the default error handler for
+// requests with a hash that does not match the current content of the file,
+// which are typically from a previous deploy. The default is 410 Gone, which
+// tells clients and crawlers the old version is permanently gone.
+func WithStaleStatus(code int) Option {
+	return func(s *Server) {
+		s.staleCode = code
+	}
+}
+
+// WithVerboseErrors responds with the error text instead of the status text
+// when using the default error handler. The error text includes file names,
+// so it is meant for development, and is the default with WithDevMode.
+func WithVerboseErrors() Option {
+	return func(s *Server) {
+		s.verboseErrors = true
+	}
+}
+
+// WithErrorLog configures a function called with the error for every request
+// which fails, such as for logging, before the response is written.
+func WithErrorLog(f func(r *http.Request, err error)) Option {
+	return func(s *Server) {
+		s.errorLog = f
+	}
+}
+
+// WithHeaderFunc configures a function called before each file is served, with
+// the original filename and its FileInfo, allowing additional headers to be
+// set. The Cache-Control and ETag headers are already set and may be changed.
+func WithHeaderFunc(f func(w http.ResponseWriter, r *http.Request, name string, info fs.FileInfo)) Option {
+	return func(s *Server) {
+		s.headerFunc = f
+	}
+}
+
+// WithCacheStatus enables the Cache-Status response header described in RFC
+// 9211, indicating whether the response was served from memory, such as from
+// the content cache, or read from the file system. The detail parameter
+// identifies precompressed, compressed and rewritten responses.
+func WithCacheStatus() Option {
+	return func(s *Server) {
+		s.cacheStatus = true
+	}
+}
+
+// WithIndex configures the index files served for directory paths, such as /
+// or /docs/, with Cache-Control: no-cache. The first one found is served. The
+// default is index.html, and no names disables serving directory paths.
+func WithIndex(names ...string) Option {
+	return func(s *Server) {
+		s.indexes = names
+	}
+}
+
+// WithFallback serves the filename, typically index.html, for requests that do
+// not match any file, with Cache-Control: no-cache. This allows serving single
+// page applications using client side routing. Requests for outdated hashed
+// paths of existing files still fail.
+func WithFallback(filename string) Option {
+	return func(s *Server) {
+		s.fallback = filename
+	}
+}
+
+// WithPassthrough serves files matching the patterns at their literal paths
+// with the Cache-Control header value, for well-known paths such as
+// robots.txt, favicon.ico or .well-known/*. Patterns use the path.Match syntax,
+// and patterns without a slash match the base name of files in any directory.
+// It may be used multiple times for different Cache-Control values.
+func WithPassthrough(cacheControl string, patterns ...string) Option {
+	return func(s *Server) {
+		s.passthrough = append(s.passthrough, passthrough{
+			cacheControl: cacheControl,
+			patterns:     patterns,
+		})
+	}
+}
+
+// WithExclude excludes files matching the patterns from hashing, so their
+// paths stay unchanged and they are served at their literal paths with
+// Cache-Control: no-cache. This is useful for service workers and HTML entry
+// points which need stable URLs. Patterns use the same syntax as
+// WithPassthrough.
+func WithExclude(patterns ...string) Option {
+	return func(s *Server) {
+		s.exclude = append(s.exclude, patterns...)
+		WithPassthrough("no-cache", patterns...)(s)
+	}
+}
+
+// WithHidden refuses to hash or serve files matching the patterns, which are
+// not found as if they did not exist, such as editor swap files or source maps
+// not meant for the public. Patterns use the same syntax as WithPassthrough.
+func WithHidden(patterns ...string) Option {
+	return func(s *Server) {
+		s.hidden = append(s.hidden, patterns...)
+	}
+}
+
+// WithHideDotfiles refuses to hash or serve files with a path element starting
+// with a dot, such as .DS_Store or .git/config, like WithHidden. The
+// .well-known directory is exempt.
+func WithHideDotfiles() Option {
+	return func(s *Server) {
+		s.hideDotfiles = true
+	}
+}
+
+// passthrough is a set of patterns served at their literal paths.
+type passthrough struct {
+	cacheControl string
+	patterns     []string
+}
+
+// WithCachePolicy configures the Cache-Control header value sent with hashed
+// responses for files matching the patterns, instead of immutable caching with
+// the max-age configured by WithMaxAge. For example fonts may be cached for a
+// year while JSON data is cached for a minute. Patterns use the same syntax as
+// WithPassthrough, and match the original filename. It may be used multiple
+// times, and the first matching policy applies.
+func WithCachePolicy(cacheControl string, patterns ...string) Option {
+	return func(s *Server) {
+		s.cachePolicies = append(s.cachePolicies, passthrough{
+			cacheControl: cacheControl,
+			patterns:     patterns,
+		})
+	}
+}
+
+// WithQueryVersion embeds the hash as a query parameter, for example
+// main.js?v=<hash>, instead of renaming files. Responses are cached immutably
+// when the v parameter matches the hash, and with Cache-Control: no-cache
+// otherwise.
+func WithQueryVersion() Option {
+	return func(s *Server) {
+		s.queryVersion = true
+	}
+}
+
+// WithStaleGrace enables serving the current content of a file requested using
+// an outdated hash, such as by clients holding HTML from a previous deploy. The
+// response is cached for maxAge instead of immutably.
+func WithStaleGrace(maxAge time.Duration) Option {
+	return func(s *Server) {
+		s.staleMaxAge = maxAge
+	}
+}
+
+// minHashLength is the shortest hash length, below which collisions are
+// likely.
+const minHashLength = 4
+
+// WithHashLength configures the number of digest bytes embedded in paths. The
+// default is 6. Longer hashes trade URL length for collision resistance.
+// Lengths below 4 use 4.
+func WithHashLength(n int) Option {
+	return func(s *Server) {
+		s.hashLength = max(n, minHashLength)
+	}
+}
+
+// WithHash configures the hash function used to fingerprint files. The default
+// is SHA-256, but a faster non-cryptographic hash such as xxhash is sufficient
+// for cache busting large asset trees.
+func WithHash(h func() hash.Hash) Option {
+	return func(s *Server) {
+		s.newHash = h
+		s.customHash = true
+	}
+}
+
+// Server provides hashed paths for, and serves the contents of, a file system.
+// Each Server maintains its own cache of hashes.
+//
+// Files opened as *os.File, such as with os.DirFS, are served without copying
+// them through user space, as net/http uses sendfile for them. Responses which
+// are compressed, rewritten, or served from the content cache are copied.
+type Server struct {
+	fs  fs.FS
+	hfs http.Handler
+
+	encoding            Encoding
+	hashLength          int
+	newHash             func() hash.Hash
+	hashers             sync.Pool // reset hash.Hash values from newHash
+	customHash          bool
+	integrity           crypto.Hash
+	maxAge              time.Duration
+	baseURL             string
+	prefix              string
+	dev                 bool
+	redirectCode        int
+	serveUnhashed       bool
+	passthrough         []passthrough
+	cachePolicies       []passthrough
+	exclude             []string
+	hidden              []string
+	hideDotfiles        bool
+	listing             bool
+	renderListing       func(http.ResponseWriter, *http.Request, string, []DirEntry)
+	fallback            string
+	indexes             []string
+	errorLog            func(*http.Request, error)
+	verboseErrors       bool
+	staleCode           int
+	signed              *signer
+	mmap                []string
+	hashLimit           chan struct{}
+	priority            []string
+	errorHandler        func(http.ResponseWriter, *http.Request, error)
+	headerFunc          func(http.ResponseWriter, *http.Request, string, fs.FileInfo)
+	onServe             []func(*http.Request, ServeEvent)
+	cacheObservers      []func(string, bool)
+	hashObservers       []func(context.Context, string, time.Time, time.Duration)
+	missingTTL          time.Duration
+	revalidate          time.Duration
+	noCache             bool
+	hashCache           *hashCache
+	cache               Cache
+	compoundExts        []string
+	namer               Namer
+	queryVersion        bool
+	contentAddressed    bool
+	staleMaxAge         time.Duration
+	manifestOnly        atomic.Bool
+	compression         bool
+	encodings           []string
+	imageTypes          []string
+	debugHeaders        bool
+	cacheStatus         bool
+	accessRecorder      func(string)
+	contentCache        *lru[contentKey]
+	contentCacheMaxFile int64
+	contentTypeFunc     func(string) string
+	contentTypes        map[string]string
+	unknownContentType  func(string) string
+	charset             string
+	cors                *cors
+	modTime             time.Time
+	validators          Validators
+
+	hashes          sync.Map
+	css             sync.Map
+	js              sync.Map
+	integrities     sync.Map
+	siblings        sync.Map
+	compressedCache *lru[compressedKey]
+	previous        sync.Map      // previous hashed path to filename
+	inflight        sync.Map      // filename to *hashCall
+	generation      atomic.Uint64 // incremented when cached data is dropped
+	missing         sync.Map      // filename to missingEntry
+	addressed       sync.Map      // content addressed path to filename
+	counters        cacheCounters
+}
+
+// New returns a Server for the file system configured with the given options.
+func New(fs fs.FS, opts ...Option) *Server {
+	s := &Server{
+		fs:         fs,
+		hfs:        http.FileServerFS(fs),
+		encoding:   Hex,
+		hashLength: 6,
+		newHash:    sha256.New,
+		integrity:  crypto.SHA256,
+		encodings:  []string{"zstd", "br", "gzip"},
+		maxAge:     31557600 * time.Second,
+		indexes:    []string{"index.html"},
+		staleCode:  http.StatusGone,
+
+		compressedCache: newLRU[compressedKey](defaultCompressionCache),
+	}
+	for _, o := range opts {
+		o(s)
+	}
+	return s
+}
+
+var defaultServers sync.Map
+
+// defaultServer returns the shared Server with default settings used by the
+// package level functions. File systems which cannot be used as a key, such as
+// a func type, get a new Server each time, and are hashed on every call.
+func defaultServer(fs fs.FS) *Server {
+	key, ok := serverKey(fs)
+	if !ok {
+		return New(fs)
+	}
+	s, found := defaultServers.Load(key)
+	if !found {
+		s, _ = defaultServers.LoadOrStore(key, New(fs))
+	}
+	return s.(*Server)
+}
+
+// referenceKey identifies a map or slice file system, such as fstest.MapFS,
+// by the data it references, since they are not comparable.
+type referenceKey struct {
+	t   reflect.Type
+	p   uintptr
+	len int
+}
+
+// serverKey returns the key for the file system in defaultServers, and false
+// if it has none. Using a value which is not comparable as a key panics.
+func serverKey(fsys fs.FS) (any, bool) {
+	v := reflect.ValueOf(fsys)
+	if !v.IsValid() || v.Comparable() {
+		return fsys, true
+	}
+	switch v.Kind() {
+	case reflect.Map:
+		return referenceKey{t: v.Type(), p: v.Pointer()}, true
+	case reflect.Slice:
+		return referenceKey{t: v.Type(), p: v.Pointer(), len: v.Len()}, true
+	}
+	return nil, false
+}
+
+type maxAgeKey struct{}
+
+// WithMaxAgeContext returns a context which overrides the max-age for the
+// response to a request using it, and marks the response as private. This
+// allows authentication middleware to shorten caching for private assets.
+func WithMaxAgeContext(ctx context.Context, d time.Duration) context.Context {
+	return context.WithValue(ctx, maxAgeKey{}, d)
+}
+
+// immutable returns the Cache-Control header value for hashed responses for
+// filename.
+func (s *Server) immutable(ctx context.Context, filename string) string {
+	if d, ok := ctx.Value(maxAgeKey{}).(time.Duration); ok {
+		return fmt.Sprintf("private, immutable, max-age=%d", int64(d.Seconds()))
+	}
+	for _, p := range s.cachePolicies {
+		if matchAny(p.patterns, filename) {
+			return p.cacheControl
+		}
+	}
+	return fmt.Sprintf("public, immutable, max-age=%d", int64(s.maxAge.Seconds()))
+}
+
+func isCSSFilename(filename string) bool {
+	return path.Ext(filename) == ".css"
+}
+
+// FileServer returns a handler that serves HTTP requests with the contents
+// of the file system rooted at root. It will expect the requests to contain
+// hashed paths. Use WithPrefix to serve them under a prefix, which is also
+// added to generated paths, or http.StripPrefix to only remove it from
+// requests.
+//
+// Files that do not implement io.Seeker, such as compressed entries in a
+// zip.Reader, are always served in full with their Content-Length. Range
+// requests require a seekable file.
+//
+// Without options the handler shares its cache with the package level
+// functions. With options it is equivalent to New, and paths must be
+// generated using a Server with the same options, or by using New directly.
+func FileServer(fs fs.FS, opts ...Option) http.Handler {
+	if len(opts) == 0 {
+		return defaultServer(fs)
+	}
+	return New(fs, opts...)
+}
+
+// ServeHTTP serves the file for the hashed path in the request. It behaves
+// like the handler returned by FileServer, using the settings of the Server.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if len(s.onServe) != 0 {
+		sr := &serveRecorder{ResponseWriter: w}
+		start := time.Now()
+		defer func() {
+			e := sr.event(start)
+			for _, f := range s.onServe {
+				f(r, e)
+			}
+		}()
+		w = sr
+	}
+
+	if s.prefix != "" {
+		trimmed := strings.TrimSuffix(s.prefix, "/")
+		if r.URL.Path != trimmed && !strings.HasPrefix(r.URL.Path, trimmed+"/") {
+			s.serveError(w, r, fmt.Errorf("hashfs: path %q outside prefix: %w", r.URL.Path, fs.ErrNotExist))
+			return
+		}
+		http.StripPrefix(trimmed, http.HandlerFunc(s.serveHTTP)).ServeHTTP(w, r)
+		return
+	}
+	s.serveHTTP(w, r)
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	urlpath := strings.TrimPrefix(r.URL.Path, "/")
+	if err := checkPath(urlpath); err != nil {
+		s.serveError(w, r, err)
+		return
+	}
+	if s.dev {
+		if s.isHidden(urlpath) {
+			s.serveError(w, r, fmt.Errorf("hashfs: hidden file %q: %w", r.URL.Path, fs.ErrNotExist))
+			return
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		// http.FileServer serves the index file of directories itself, and
+		// redirects to add the trailing slash
+		if (urlpath == "" || strings.HasSuffix(urlpath, "/")) && s.isDir(urlpath) &&
+			!s.exists(path.Join(urlpath, "index.html")) {
+			s.serveListing(w, r, urlpath)
+			return
+		}
+		s.hfs.ServeHTTP(w, r)
+		return
+	}
+
+	if urlpath == "" || strings.HasSuffix(urlpath, "/") {
+		for _, index := range s.indexes {
+			if s.exists(urlpath + index) {
+				s.serveFile(w, r, urlpath+index, "no-cache")
+				return
+			}
+		}
+		if s.isDir(urlpath) {
+			s.serveListing(w, r, urlpath)
+			return
+		}
+	}
+	for _, p := range s.passthrough {
+		if matchAny(p.patterns, urlpath) && s.exists(urlpath) {
+			s.serveFile(w, r, urlpath, p.cacheControl)
+			return
+		}
+	}
+	if s.queryVersion {
+		s.serveQueryVersion(w, r, urlpath)
+		return
+	}
+	filename, err := s.unhashed(r.Context(), urlpath)
+	if err != nil {
+		if s.redirectCode != 0 && s.redirectUnhashed(w, r, urlpath) {
+			return
+		}
+		if s.serveUnhashed && s.exists(urlpath) {
+			s.serveFile(w, r, urlpath, "no-cache")
+			return
+		}
+		if s.servePrevious(w, r, urlpath) {
+			return
+		}
+		if s.staleMaxAge > 0 && errors.Is(err, ErrPathMismatch) {
+			s.serveFile(w, r, s.splitHashed(urlpath), fmt.Sprintf("public, max-age=%d", int64(s.staleMaxAge.Seconds())))
+			return
+		}
+		if s.fallback != "" && errors.Is(err, fs.ErrNotExist) && s.exists(s.fallback) {
+			s.serveFile(w, r, s.fallback, "no-cache")
+			return
+		}
+		s.serveError(w, r, err)
+		return
+	}
+	s.serveFile(w, r, filename, s.immutable(r.Context(), filename))
+}
+
+// ServeFile serves filename if the request path is its hashed path, using the
+// default Server for the file system.
+func ServeFile(w http.ResponseWriter, r *http.Request, fsys fs.FS, filename string) {
+	defaultServer(fsys).ServeFile(w, r, filename)
+}
+
+// ServeFile serves filename if the request path is its hashed path, for routes
+// which map a single URL to a single file. The request path may have a prefix,
+// or only contain the last element of the hashed path, such as with a route
+// for /favicon.<hash>.ico serving static/favicon.ico.
+func (s *Server) ServeFile(w http.ResponseWriter, r *http.Request, filename string) {
+	if s.queryVersion {
+		s.serveQueryVersion(w, r, filename)
+		return
+	}
+	e, err := s.hashContext(r.Context(), filename)
+	if err != nil {
+		s.serveError(w, r, err)
+		return
+	}
+	urlpath, hashed := strings.TrimPrefix(r.URL.Path, "/"), e.path
+	// the last element can only identify the file if it contains the hash
+	baseHashed := path.Base(hashed) != path.Base(filename)
+	if s.encoding == Base32 {
+		urlpath, hashed = strings.ToLower(urlpath), strings.ToLower(hashed)
+	}
+	if urlpath != hashed && !strings.HasSuffix(urlpath, "/"+hashed) &&
+		(!baseHashed || path.Base(urlpath) != path.Base(hashed)) {
+		s.serveError(w, r, fmt.Errorf("%w for %q", ErrPathMismatch, urlpath))
+		return
+	}
+	s.serveFile(w, r, filename, s.immutable(r.Context(), filename))
+}
+
+// serveQueryVersion serves filename with immutable caching if the v query
+// parameter matches its hash.
+func (s *Server) serveQueryVersion(w http.ResponseWriter, r *http.Request, filename string) {
+	e, err := s.hashContext(r.Context(), filename)
+	if err != nil {
+		s.serveError(w, r, err)
+		return
+	}
+	v := r.URL.Query().Get("v")
+	if v != "" && (v == e.hash || (s.encoding == Base32 && strings.EqualFold(v, e.hash))) {
+		s.serveFile(w, r, filename, s.immutable(r.Context(), filename))
+		return
+	}
+	s.serveFile(w, r, filename, "no-cache")
+}
+
+// serveFile serves filename with the Cache-Control header value.
+func (s *Server) serveFile(w http.ResponseWriter, r *http.Request, filename, cacheControl string) {
+	if s.signed != nil && matchAny(s.signed.patterns, filename) {
+		ttl, err := s.verifySignature(r, filename)
+		if err != nil {
+			s.serveError(w, r, err)
+			return
+		}
+		cacheControl = fmt.Sprintf("private, max-age=%d", int64(ttl.Seconds()))
+	}
+	if s.accessRecorder != nil {
+		defer s.accessRecorder(filename)
+	}
+	w.Header().Set("Cache-Control", cacheControl)
+	if s.debugHeaders {
+		w.Header().Set("X-Hashfs-File", filename)
+	}
+	if e, err := s.hashContext(r.Context(), filename); err == nil && e.hash != "" {
+		if s.validators != ValidateLastModified {
+			w.Header().Set("ETag", `"`+e.hash+`"`)
+		}
+		if sr, ok := w.(*serveRecorder); ok {
+			sr.hash = e.hash
+		}
+	}
+	if sr, ok := w.(*serveRecorder); ok {
+		sr.filename = filename
+	}
+	if s.setCORS(w, r, filename) {
+		return
+	}
+	if s.headerFunc != nil {
+		if fi, err := fs.Stat(s.fs, filename); err == nil {
+			s.headerFunc(w, r, filename, fi)
+		}
+	}
+
+	if len(s.encodings) > 0 {
+		// every response varies once encodings are negotiated, even those
+		// served unencoded, so caches don't mix the variants
+		addVary(w.Header(), "Accept-Encoding")
+	}
+
+	if isRewritable(filename) {
+		content, ok := s.rewritten(filename)
+		if ok {
+			w.Header().Set("Content-Type", s.contentType(filename))
+			s.setCharset(w.Header())
+			// precompressed siblings hold the content before it was rewritten,
+			// so they are replaced by compressing on the fly
+			if (s.compression || len(s.siblingEncodings(filename)) > 0) && s.serveCompressed(w, r, filename) {
+				return
+			}
+			if s.rejectIdentity(w, r) {
+				return
+			}
+			s.setCacheStatus(w.Header(), "hit; detail=rewritten")
+			http.ServeContent(w, r, filename, s.lastModified(time.Time{}), strings.NewReader(content))
+			return
+		}
+	}
+
+	served := filename
+	if variant := s.negotiateImage(w, r, filename); variant != "" {
+		served = variant
+		if s.debugHeaders {
+			w.Header().Set("X-Hashfs-File", served)
+		}
+	}
+
+	s.setContentType(w.Header(), served)
+	if s.charset != "" && w.Header().Get("Content-Type") == "" {
+		if ctype := s.contentType(served); ctype != "" {
+			w.Header().Set("Content-Type", ctype)
+		}
+	}
+	s.setCharset(w.Header())
+	var detail string
+	if sibling := s.negotiateEncoding(w, r, served); sibling != "" {
+		served = sibling
+		detail = "; detail=precompressed"
+	} else if s.compression && s.serveCompressed(w, r, served) {
+		return
+	} else if s.rejectIdentity(w, r) {
+		return
+	}
+
+	if s.contentCache != nil && !matchAny(s.mmap, served) && s.serveCached(w, r, served, detail) {
+		return
+	}
+	s.setCacheStatus(w.Header(), "fwd=miss"+detail)
+
+	if f, err := s.fs.Open(served); err == nil {
+		defer f.Close()
+		if fi, err := f.Stat(); err == nil && !fi.IsDir() {
+			modTime := s.lastModified(fi.ModTime())
+			rs, ok := f.(io.ReadSeeker)
+			if !ok {
+				if !modTime.IsZero() {
+					w.Header().Set("Last-Modified", modTime.UTC().Format(http.TimeFormat))
+				}
+				if notModified(w, r) {
+					return
+				}
+				serveUnseekable(w, r, served, f, fi)
+				return
+			}
+			if s.mmap != nil && s.serveMmap(w, r, served, modTime, f, fi) {
+				return
+			}
+			// serving the open file directly avoids opening it again in
+			// http.FileServer, which also redirects index.html to the directory
+			http.ServeContent(w, r, served, modTime, rs)
+			return
+		}
+	}
+
+	// the path was decoded before it was unhashed, so the raw path of the
+	// request no longer applies. only the URL is copied, as http.FileServer
+	// does not modify the request.
+	u := *r.URL
+	u.Path = "/" + served
+	u.RawPath = ""
+	shallow := *r
+	shallow.URL = &u
+	s.hfs.ServeHTTP(w, &shallow)
+}
+
+// setCacheStatus sets the Cache-Status header described in RFC 9211, if
+// enabled.
+func (s *Server) setCacheStatus(h http.Header, status string) {
+	if s.cacheStatus {
+		h.Set("Cache-Status", "hashfs; "+status)
+	}
+}
+
+// notModified writes a 304 response and returns true if the If-None-Match
+// header matches the ETag of the response, or in its absence the
+// If-Modified-Since header is not before the Last-Modified time of the
+// response. It is used where the content is not served by http.ServeContent,
+// which handles this itself.
+func notModified(w http.ResponseWriter, r *http.Request) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		etag := strings.TrimPrefix(w.Header().Get("ETag"), "W/")
+		if etag == "" {
+			return false
+		}
+		for candidate := range strings.SplitSeq(inm, ",") {
+			candidate = strings.TrimSpace(candidate)
+			if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+				w.WriteHeader(http.StatusNotModified)
+				return true
+			}
+		}
+		return false
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	modTime, err := http.ParseTime(w.Header().Get("Last-Modified"))
+	if err != nil {
+		return false
+	}
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil || modTime.After(since) {
+		return false
+	}
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// isHidden reports if filename is hidden by WithHidden or WithHideDotfiles.
+func (s *Server) isHidden(filename string) bool {
+	if s.hideDotfiles {
+		for elem := range strings.SplitSeq(filename, "/") {
+			if len(elem) > 1 && elem[0] == '.' && elem != ".well-known" {
+				return true
+			}
+		}
+	}
+	return matchAny(s.hidden, filename)
+}
+
+// matchAny returns true if the name matches any of the patterns. Patterns
+// without a slash match the base name.
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if !strings.Contains(pattern, "/") {
+			if matched, _ := path.Match(pattern, path.Base(name)); matched {
+				return true
+			}
+			continue
+		}
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// exists reports if filename is a regular file which is not hidden.
+func (s *Server) exists(filename string) bool {
+	if s.isHidden(filename) {
+		return false
+	}
+	fi, err := fs.Stat(s.fs, filename)
+	return err == nil && !fi.IsDir()
+}
+
+// redirectUnhashed redirects to the hashed path if urlpath is an unhashed
+// filename. The Location is relative, so it works behind http.StripPrefix.
+func (s *Server) redirectUnhashed(w http.ResponseWriter, r *http.Request, urlpath string) bool {
+	e, err := s.hash(urlpath)
+	if err != nil {
+		return false
+	}
+	// relative to the request, which may be behind a prefix
+	location := escapePath(relativePath(path.Dir(urlpath), e.path))
+	if r.URL.RawQuery != "" {
+		sep := "?"
+		if strings.Contains(location, "?") {
+			sep = "&"
+		}
+		location += sep + r.URL.RawQuery
+	}
+	w.Header().Set("Location", location)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(s.redirectCode)
+	return true
+}
+
+// serveError responds with the error using the configured error handler, or
+// by default using 404 Not Found for missing files, the stale status for
+// outdated hashes, 403 Forbidden for invalid signatures and 400 Bad Request
+// otherwise. The error text is only sent with verbose errors, since it
+// includes file names.
+func (s *Server) serveError(w http.ResponseWriter, r *http.Request, err error) {
+	if s.errorLog != nil {
+		s.errorLog(r, err)
+	}
+	if s.errorHandler != nil {
+		s.errorHandler(w, r, err)
+		return
+	}
+	code := http.StatusBadRequest
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		code = http.StatusNotFound
+	case errors.Is(err, ErrPathMismatch):
+		code = s.staleCode
+	case errors.Is(err, ErrInvalidSignature):
+		code = http.StatusForbidden
+	case errors.Is(err, ErrNotAcceptable):
+		code = http.StatusNotAcceptable
+	}
+	if s.verboseErrors || s.dev {
+		http.Error(w, fmt.Sprint(err), code)
+		return
+	}
+	http.Error(w, http.StatusText(code), code)
+}
+
+// setContentType sets the Content-Type if one is configured for name, leaving
+// the rest to detection by extension and content.
+func (s *Server) setContentType(h http.Header, name string) {
+	if ctype := s.configuredContentType(name); ctype != "" {
+		h.Set("Content-Type", ctype)
+	}
+}
+
+// setCharset sets the configured charset on a text Content-Type.
+func (s *Server) setCharset(h http.Header) {
+	if s.charset == "" {
+		return
+	}
+	mediaType, params, err := mime.ParseMediaType(h.Get("Content-Type"))
+	if err != nil || !isText(mediaType) {
+		return
+	}
+	params["charset"] = s.charset
+	h.Set("Content-Type", mime.FormatMediaType(mediaType, params))
+}
+
+// isText returns true if the media type is text which has a charset.
+func isText(mediaType string) bool {
+	switch mediaType {
+	case "application/javascript", "application/json", "application/xml", "image/svg+xml":
+		return true
+	}
+	return strings.HasPrefix(mediaType, "text/") ||
+		strings.HasSuffix(mediaType, "+json") || strings.HasSuffix(mediaType, "+xml")
+}
+
+// contentType returns the Content-Type for name, or an empty string if it can
+// only be detected from the content.
+func (s *Server) contentType(name string) string {
+	if ctype := s.configuredContentType(name); ctype != "" {
+		return ctype
+	}
+	return mime.TypeByExtension(path.Ext(name))
+}
+
+// configuredContentType returns the Content-Type configured for name by
+// WithContentTypeFunc, WithContentTypes or WithUnknownContentType.
+func (s *Server) configuredContentType(name string) string {
+	if s.contentTypeFunc != nil {
+		if ctype := s.contentTypeFunc(name); ctype != "" {
+			return ctype
+		}
+	}
+	ext := path.Ext(name)
+	if ctype, ok := s.contentTypes[strings.ToLower(ext)]; ok {
+		return ctype
+	}
+	if s.unknownContentType != nil && mime.TypeByExtension(ext) == "" {
+		return s.unknownContentType(name)
+	}
+	return ""
+}
+
+// negotiateImage returns the sibling variant of the image filename to serve
+// based on the Accept header, or an empty string to serve filename itself. It
+// sets the Vary and ETag headers when variants are available.
+func (s *Server) negotiateImage(w http.ResponseWriter, r *http.Request, filename string) string {
+	variants := s.imageVariants(filename)
+	if len(variants) == 0 {
+		return ""
+	}
+	var variant string
+	for _, v := range variants {
+		if accepts(r.Header.Get("Accept"), v.ctype) {
+			variant = v.name
+			break
+		}
+	}
+
+	addVary(w.Header(), "Accept")
+	served := filename
+	if variant != "" {
+		served = variant
+	}
+	if e, err := s.hash(served); err == nil && w.Header().Get("ETag") != "" {
+		w.Header().Set("ETag", `W/"`+e.hash+`"`)
+	}
+	return variant
 }
 
-func isCSSFilename(filename string) bool {
-	return path.Ext(filename) == ".css"
+type imageVariant struct {
+	name, ctype string
 }
 
-// FileServer returns a handler that serves HTTP requests with the contents
-// of the file system rooted at root. It will expect the requests to contain
-// hashed paths. Use http.StripPrefix to wrap and remove any prefixes
-// if necessary.
-func FileServer(fs fs.FS) http.Handler {
-	hfs := http.FileServerFS(fs)
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		filename, err := Unhashed(fs, strings.TrimPrefix(r.URL.Path, "/"))
-		if err != nil {
-			http.Error(w, fmt.Sprint(err), http.StatusBadRequest)
-			return
+// imageVariants returns the sibling variants of the image filename which exist,
+// in the order of the types given to WithImageNegotiation.
+func (s *Server) imageVariants(filename string) []imageVariant {
+	if len(s.imageTypes) == 0 {
+		return nil
+	}
+	ext := path.Ext(filename)
+	if !strings.HasPrefix(mime.TypeByExtension(ext), "image/") {
+		return nil
+	}
+	var variants []imageVariant
+	for _, t := range s.imageTypes {
+		exts, _ := mime.ExtensionsByType(t)
+		if len(exts) == 0 || slices.Contains(exts, ext) {
+			continue
 		}
-
-		if isCSSFilename(filename) {
-			content, err := hashCSSAssets(fs, filename)
-			if err == nil {
-				setImmutable(w.Header())
-				w.Header().Set("Content-Type", "text/css; charset=utf-8")
-				io.WriteString(w, content)
-				return
-			}
+		name := strings.TrimSuffix(filename, ext) + exts[0]
+		if _, err := fs.Stat(s.fs, name); err != nil {
+			continue
 		}
+		variants = append(variants, imageVariant{name: name, ctype: t})
+	}
+	return variants
+}
 
-		r = r.Clone(r.Context())
-		r.URL.Path = "/" + filename
-		if r.URL.RawPath != "" {
-			rawpath, err := Unhashed(fs, strings.TrimPrefix(r.URL.RawPath, "/"))
-			if err != nil {
-				http.Error(w, fmt.Sprint(err), http.StatusBadRequest)
+// mayHaveVariant reports if variant may be an image variant of filename, whose
+// hash then covers it.
+func (s *Server) mayHaveVariant(filename, variant string) bool {
+	if len(s.imageTypes) == 0 || filename == variant {
+		return false
+	}
+	ext := path.Ext(filename)
+	return strings.TrimSuffix(filename, ext) == strings.TrimSuffix(variant, path.Ext(variant)) &&
+		strings.HasPrefix(mime.TypeByExtension(ext), "image/")
+}
+
+// hasImageVariants reports if filename is an image with variants, whose hash
+// also covers them. A variant may be added without changing the image, so such
+// a hash is only valid as long as the image has no variants.
+func (s *Server) hasImageVariants(filename string) bool {
+	return len(s.imageVariants(filename)) > 0
+}
+
+// addVary adds the header name to Vary unless it is already present.
+func addVary(h http.Header, name string) {
+	for _, v := range h.Values("Vary") {
+		for existing := range strings.SplitSeq(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(existing), name) {
 				return
 			}
-			r.URL.Path = "/" + rawpath
 		}
+	}
+	h.Add("Vary", name)
+}
 
-		setImmutable(w.Header())
-		hfs.ServeHTTP(w, r)
-	})
+// accepts reports if the header value lists the media type without a zero
+// quality.
+func accepts(header, mediaType string) bool {
+	for accepted := range strings.SplitSeq(header, ",") {
+		value, params, _ := strings.Cut(accepted, ";")
+		if !strings.EqualFold(strings.TrimSpace(value), mediaType) {
+			continue
+		}
+		q, found := strings.CutPrefix(strings.TrimSpace(params), "q=")
+		return !found || strings.Trim(q, "0.") != ""
+	}
+	return false
 }
 
-func hashCSSAssets(fs fs.FS, filename string) (string, error) {
-	key := cacheKey{
-		fs:       fs,
-		filename: filename,
+// serveUnseekable serves the full content of a file which cannot seek, which
+// http.FileServerFS refuses to sniff or serve ranges from.
+func serveUnseekable(w http.ResponseWriter, r *http.Request, filename string, f fs.File, fi fs.FileInfo) {
+	var content io.Reader = f
+	if w.Header().Get("Content-Type") == "" {
+		ctype := mime.TypeByExtension(path.Ext(filename))
+		if ctype == "" {
+			var buf [512]byte
+			n, _ := io.ReadFull(f, buf[:])
+			ctype = http.DetectContentType(buf[:n])
+			content = io.MultiReader(bytes.NewReader(buf[:n]), f)
+		}
+		w.Header().Set("Content-Type", ctype)
+	}
+	w.Header().Set("Content-Length", strconv.FormatInt(fi.Size(), 10))
+	w.WriteHeader(http.StatusOK)
+	if r.Method != http.MethodHead {
+		io.Copy(w, content)
 	}
-	content, found := cachedCSS.Load(key)
+}
+
+// hashCSSAssets returns the content of the CSS file with its references
+// rewritten to hashed paths, which is ok if any reference was rewritten.
+func (s *Server) hashCSSAssets(ctx context.Context, filename string) (rewrite, error) {
+	cached, found := s.css.Load(filename)
 	if found {
-		return content.(string), nil
+		return cached.(rewrite), nil
 	}
+	gen := s.generation.Load()
 
-	f, err := fs.Open(filename)
+	f, err := s.fs.Open(filename)
 	if err != nil {
-		return "", fmt.Errorf("hashfs: unexpected error opening css file %q: %w", filename, err)
+		return rewrite{}, fmt.Errorf("hashfs: unexpected error opening css file %q: %w", filename, err)
 	}
+	defer f.Close()
 
 	var out strings.Builder
+	var changed bool
 	l := css.NewLexer(parse.NewInput(f))
 outer:
 	for {
@@ -116,8 +1295,13 @@ outer:
 					case css.WhitespaceToken:
 						out.Write(text)
 					case css.StringToken:
-						target := string(text[1 : len(text)-1])
-						hashed := transformPath(fs, filename, target)
+						target, ok := cssString(text)
+						if !ok {
+							out.Write(text)
+							continue outer
+						}
+						hashed := s.transformPath(ctx, filename, target)
+						changed = changed || hashed != target
 						out.WriteByte(text[0])
 						out.WriteString(hashed)
 						out.WriteByte(text[0])
@@ -126,7 +1310,13 @@ outer:
 				}
 			}
 		case css.URLToken:
-			out.Write(transformURL(fs, filename, text))
+			hashed := s.transformURL(ctx, filename, text)
+			changed = changed || !bytes.Equal(hashed, text)
+			out.Write(hashed)
+		case css.CommentToken:
+			rewritten := s.rewriteSourceMap(ctx, filename, string(text))
+			changed = changed || rewritten != string(text)
+			out.WriteString(rewritten)
 		case css.ErrorToken:
 			out.Write(text)
 			if errors.Is(l.Err(), io.EOF) {
@@ -135,9 +1325,21 @@ outer:
 		}
 	}
 
-	outStr := out.String()
-	cachedCSS.Store(key, outStr)
-	return outStr, nil
+	r := rewrite{content: out.String(), ok: changed, refs: rewritingFrom(ctx).references()}
+	// references are left unrewritten if the context is canceled
+	if !s.noCache && s.generation.Load() == gen && ctx.Err() == nil {
+		s.css.Store(filename, r)
+	}
+	return r, nil
+}
+
+// cssString returns the value of a CSS string token, and false if the string
+// is not terminated, such as at the end of the input.
+func cssString(text []byte) (string, bool) {
+	if len(text) < 2 || text[len(text)-1] != text[0] {
+		return "", false
+	}
+	return string(text[1 : len(text)-1]), true
 }
 
 var (
@@ -149,16 +1351,69 @@ var (
 	urlDobulePost = []byte(`")`)
 )
 
-func transformPath(fs fs.FS, basepath string, target string) string {
-	abs := path.Join(path.Dir(basepath), target)
-	hashed, err := MaybePath(fs, abs)
-	if err != nil {
+// transformPath returns the hashed version of the relative reference target
+// found in basepath. A query or fragment, such as those used in font
+// references, is preserved. Other references, including those to a file being
+// rewritten, are returned unchanged.
+func (s *Server) transformPath(ctx context.Context, basepath string, target string) string {
+	if target == "" || strings.HasPrefix(target, "/") || strings.Contains(target, ":") {
 		return target
 	}
-	return path.Join(path.Dir(target), path.Base(hashed))
+	var suffix string
+	if i := strings.IndexAny(target, "?#"); i >= 0 {
+		target, suffix = target[:i], target[i:]
+	}
+	name, err := url.PathUnescape(target)
+	if err != nil {
+		return target + suffix
+	}
+	if r := rewritingFrom(ctx); r != nil {
+		r.referenced = true
+	}
+	e, err := s.hashContext(ctx, path.Join(path.Dir(basepath), name))
+	if err != nil {
+		return target + suffix
+	}
+	// the reference is resolved relative to the hashed path of basepath,
+	// which may be in another directory than the file, such as with
+	// WithDirectoryHash or WithContentAddressed
+	hashed := relativePath(s.hashedDir(basepath), e.path)
+	if name != target {
+		hashed = escapePath(hashed)
+	}
+	if strings.HasPrefix(suffix, "?") && strings.Contains(hashed, "?") {
+		suffix = "&" + suffix[1:]
+	}
+	return hashed + suffix
 }
 
-func transformURL(fs fs.FS, basepath string, v []byte) []byte {
+// hashedDir returns the directory of the hashed path of filename, which only
+// depends on the naming scheme and not on the content of the file.
+func (s *Server) hashedDir(filename string) string {
+	return path.Dir(s.entry(filename, Digest{Sum: []byte{0}, Hash: "0"}).path)
+}
+
+// relativePath returns the path of target relative to the directory dir, where
+// both are relative to the root of the file system.
+func relativePath(dir, target string) string {
+	var from []string
+	if dir != "." && dir != "" {
+		from = strings.Split(dir, "/")
+	}
+	to := strings.Split(target, "/")
+	i := 0
+	for i < len(from) && i < len(to)-1 && from[i] == to[i] {
+		i++
+	}
+	rel := strings.Repeat("../", len(from)-i) + strings.Join(to[i:], "/")
+	// a colon in the first segment would be taken as a URL scheme
+	if first, _, _ := strings.Cut(rel, "/"); strings.Contains(first, ":") {
+		rel = "./" + rel
+	}
+	return rel
+}
+
+func (s *Server) transformURL(ctx context.Context, basepath string, v []byte) []byte {
 	pre := urlBarePre
 	post := urlBarePost
 	if bytes.HasPrefix(v, urlDobulePre) {
@@ -170,14 +1425,20 @@ func transformURL(fs fs.FS, basepath string, v []byte) []byte {
 	}
 
 	target := string(v[len(pre) : len(v)-len(post)])
-	hashed := transformPath(fs, basepath, target)
+	hashed := s.transformPath(ctx, basepath, target)
 	return slices.Concat(pre, []byte(hashed), post)
 }
 
 // Path returns the hashed path of filename. It panics if the filename is not
 // found or other errors. Use MaybePath for errors instead of panics.
 func Path(fs fs.FS, filename string) string {
-	hashed, err := MaybePath(fs, filename)
+	return defaultServer(fs).Path(filename)
+}
+
+// Path returns the hashed path of filename. It panics if the filename is not
+// found or other errors. Use MaybePath for errors instead of panics.
+func (s *Server) Path(filename string) string {
+	hashed, err := s.MaybePath(filename)
 	if err != nil {
 		panic(err)
 	}
@@ -187,47 +1448,642 @@ func Path(fs fs.FS, filename string) string {
 // MaybePath returns the hashed path of filename. The hash is injected before
 // the extension, or at the end if an extension is not found.
 func MaybePath(fs fs.FS, filename string) (string, error) {
-	key := cacheKey{
-		fs:       fs,
-		filename: filename,
+	return defaultServer(fs).MaybePath(filename)
+}
+
+// MaybePath returns the hashed path of filename. The hash is injected before
+// the extension, or at the end if an extension is not found. The path is
+// prefixed with the base URL, if one is configured.
+func (s *Server) MaybePath(filename string) (string, error) {
+	if s.dev {
+		if _, err := fs.Stat(s.fs, filename); err != nil {
+			return "", fmt.Errorf("hashfs: error opening file: %w", err)
+		}
+		return s.urlPrefix() + filename, nil
 	}
-	urlpath, found := cachedHashes.Load(key)
+	e, err := s.hash(filename)
+	if err != nil {
+		return "", err
+	}
+	return s.urlPrefix() + e.path, nil
+}
+
+// EscapedPath returns the hashed path of filename like MaybePath, with the path
+// percent-encoded for use in URLs, such as for filenames with spaces or
+// non-ASCII characters.
+func EscapedPath(fs fs.FS, filename string) (string, error) {
+	return defaultServer(fs).EscapedPath(filename)
+}
+
+// EscapedPath returns the hashed path of filename like MaybePath, with the path
+// percent-encoded for use in URLs, such as for filenames with spaces or
+// non-ASCII characters. The base URL or prefix is used as is.
+func (s *Server) EscapedPath(filename string) (string, error) {
+	hashed, err := s.MaybePath(filename)
+	if err != nil {
+		return "", err
+	}
+	prefix := s.urlPrefix()
+	return prefix + escapePath(strings.TrimPrefix(hashed, prefix)), nil
+}
+
+// escapePath percent-encodes the path, leaving the query of query versioned
+// paths intact.
+func escapePath(p string) string {
+	p, query, found := strings.Cut(p, "?")
+	p = (&url.URL{Path: p}).EscapedPath()
+	if found {
+		p += "?" + query
+	}
+	return p
+}
+
+// MaybePathContext is MaybePath with a context, which stops hashing a file
+// early if it is canceled, using the default Server for the file system.
+func MaybePathContext(ctx context.Context, fs fs.FS, filename string) (string, error) {
+	return defaultServer(fs).MaybePathContext(ctx, filename)
+}
+
+// MaybePathContext is MaybePath with a context, which stops hashing a file
+// early if it is canceled, such as a large file on a slow network backed file
+// system. Other callers waiting for the same file are not affected.
+func (s *Server) MaybePathContext(ctx context.Context, filename string) (string, error) {
+	if s.dev {
+		return s.MaybePath(filename)
+	}
+	e, err := s.hashContext(ctx, filename)
+	if err != nil {
+		return "", err
+	}
+	return s.urlPrefix() + e.path, nil
+}
+
+// urlPrefix returns the prefix for the paths returned by Path, which is the
+// base URL if configured and otherwise the prefix.
+func (s *Server) urlPrefix() string {
+	if s.baseURL != "" {
+		return s.baseURL
+	}
+	return s.prefix
+}
+
+// ext returns the extension the hash is placed before, which is the last
+// extension unless a compound extension matches.
+func (s *Server) ext(filename string) string {
+	for _, ext := range s.compoundExts {
+		if len(filename) > len(ext) && strings.HasSuffix(filename, ext) {
+			return ext
+		}
+	}
+	return filepath.Ext(filename)
+}
+
+// hashEntry is the cached result of hashing a file. The full digest is kept
+// so integrity values can be derived from the same single read of the file.
+type hashEntry struct {
+	path     string    // the hashed path
+	hash     string    // the encoded hash embedded in path
+	digest   []byte    // the full digest
+	manifest bool      // loaded from a manifest
+	modTime  time.Time // the modification time, if known
+	size     int64     // the size, if known
+	checked  time.Time // when the file was last hashed or revalidated
+	derived  bool      // the hash also depends on other files
+}
+
+// content opens the content served for filename, which is the rewritten
+// content for CSS and JavaScript files.
+func (s *Server) content(filename string) (io.ReadCloser, error) {
+	r, _, err := s.contentContext(context.Background(), filename)
+	return r, err
+}
+
+// contentContext is content with the context the files referenced by
+// rewritten content are hashed with. It also reports if the content references
+// other files, so its hash depends on them.
+func (s *Server) contentContext(ctx context.Context, filename string) (io.ReadCloser, bool, error) {
+	var refs bool
+	if isRewritable(filename) {
+		r := s.rewriteContext(ctx, filename)
+		if r.ok {
+			return io.NopCloser(strings.NewReader(r.content)), true, nil
+		}
+		refs = r.refs
+	}
+	f, err := s.fs.Open(filename)
+	if err != nil {
+		return nil, false, fmt.Errorf("hashfs: error opening file: %w", err)
+	}
+	return f, refs, nil
+}
+
+func (s *Server) hash(filename string) (*hashEntry, error) {
+	return s.hashContext(context.Background(), filename)
+}
+
+// hashContext is hash with the context of the request it is used for, which is
+// passed to the hash observers.
+func (s *Server) hashContext(ctx context.Context, filename string) (*hashEntry, error) {
+	// an invalid filename cannot exist, such as one derived from a malformed
+	// hashed path
+	if !validPath(filename) {
+		return nil, fmt.Errorf("%w %q: %w", ErrInvalidPath, filename, fs.ErrNotExist)
+	}
+	if s.isHidden(filename) {
+		return nil, fmt.Errorf("hashfs: hidden file %q: %w", filename, fs.ErrNotExist)
+	}
+	cached, found := s.hashes.Load(filename)
+	s.observeCache("hash", found)
+	var stale *hashEntry
 	if found {
-		return urlpath.(string), nil
+		e := cached.(*hashEntry)
+		if s.revalidate <= 0 || e.checked.IsZero() || time.Since(e.checked) < s.revalidate {
+			return e, nil
+		}
+		if s.unchanged(filename, e) {
+			checked := *e
+			checked.checked = time.Now()
+			s.hashes.CompareAndSwap(filename, e, &checked)
+			return &checked, nil
+		}
+		stale = e
+		s.forget(filename)
+	}
+	if s.manifestOnly.Load() {
+		return nil, fmt.Errorf("hashfs: file not in manifest %q: %w", filename, fs.ErrNotExist)
+	}
+	rewriting := rewritingFrom(ctx)
+	if rewriting.contains(filename) {
+		return nil, fmt.Errorf("hashfs: reference cycle hashing file %q", filename)
+	}
+	if cached, found := s.missing.Load(filename); found {
+		m := cached.(missingEntry)
+		if time.Now().Before(m.expires) {
+			return nil, m.err
+		}
+		s.missing.CompareAndDelete(filename, m)
+	}
+
+	// concurrent callers for the same file wait for a single computation
+	c := &hashCall{done: make(chan struct{})}
+	if inflight, loaded := s.inflight.LoadOrStore(filename, c); loaded {
+		// a file referenced while rewriting is hashed again instead, since
+		// the computation may be waiting on the file being rewritten
+		if rewriting != nil {
+			return s.computeHash(ctx, filename)
+		}
+		c = inflight.(*hashCall)
+		select {
+		case <-c.done:
+		case <-ctx.Done():
+			return nil, fmt.Errorf("hashfs: error hashing file %q: %w", filename, ctx.Err())
+		}
+		// the computation was canceled by the context of another caller
+		if isContextErr(c.err) && ctx.Err() == nil {
+			return s.hashContext(ctx, filename)
+		}
+		return c.e, c.err
+	}
+	start := time.Now()
+	gen := s.generation.Load()
+	c.e, c.err = s.computeHash(ctx, filename)
+	current := s.generation.Load() == gen
+	if c.err == nil {
+		for _, f := range s.hashObservers {
+			f(ctx, filename, start, time.Since(start))
+		}
+	}
+	// files referencing a changed file are rewritten again
+	if stale != nil && (c.err != nil || c.e.path != stale.path) {
+		s.Invalidate(filename)
+	}
+	if c.err == nil {
+		if !s.noCache && current && !s.manifestOnly.Load() {
+			s.hashes.Store(filename, c.e)
+		}
+		if s.contentAddressed {
+			s.addressed.Store(c.e.path, filename)
+		}
+	} else if s.missingTTL > 0 && errors.Is(c.err, fs.ErrNotExist) {
+		s.missing.Store(filename, missingEntry{err: c.err, expires: time.Now().Add(s.missingTTL)})
+	}
+	s.inflight.CompareAndDelete(filename, c)
+	close(c.done)
+	return c.e, c.err
+}
+
+func isContextErr(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// missingEntry is a cached not found error.
+type missingEntry struct {
+	err     error
+	expires time.Time
+}
+
+// hashCall is an in-flight or completed hash computation.
+type hashCall struct {
+	done chan struct{}
+	e    *hashEntry
+	err  error
+}
+
+// computeHash hashes the content of filename, stopping early if the context
+// is canceled.
+func (s *Server) computeHash(ctx context.Context, filename string) (*hashEntry, error) {
+	if s.hashCache != nil {
+		if e, ok := s.lookupHashCache(filename); ok {
+			return e, nil
+		}
+	}
+	if s.cache != nil {
+		if e, ok := s.lookupCache(ctx, filename); ok {
+			if s.hashCache != nil {
+				s.storeHashCache(filename, e)
+			}
+			return e, nil
+		}
+	}
+	r, refs, err := s.contentContext(ctx, filename)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	// acquired after opening, since rewriting content hashes the files it
+	// references
+	if s.hashLimit != nil {
+		select {
+		case s.hashLimit <- struct{}{}:
+			defer func() { <-s.hashLimit }()
+		case <-ctx.Done():
+			return nil, fmt.Errorf("hashfs: error hashing file %q: %w", filename, ctx.Err())
+		}
+	}
+	// the hashed path of a negotiated image also serves its variants, so it
+	// changes with them
+	content := io.Reader(r)
+	variants := s.imageVariants(filename)
+	if len(variants) > 0 {
+		readers := []io.Reader{r}
+		for _, v := range variants {
+			f, err := s.fs.Open(v.name)
+			if err != nil {
+				return nil, fmt.Errorf("hashfs: error opening file: %w", err)
+			}
+			defer f.Close()
+			readers = append(readers, f)
+		}
+		content = io.MultiReader(readers...)
+	}
+	d, err := s.HashReader(contextReader{ctx: ctx, r: content})
+	if err != nil {
+		return nil, fmt.Errorf("hashfs: error hashing file %q: %w", filename, err)
+	}
+	e := s.entry(filename, d)
+	e.checked = time.Now()
+	e.derived = refs || len(variants) > 0
+	// rewritten content changes with the files it references
+	if f, ok := r.(fs.File); ok {
+		if fi, err := f.Stat(); err == nil {
+			e.modTime = fi.ModTime()
+			e.size = fi.Size()
+		}
+	}
+	if s.hashCache != nil {
+		s.storeHashCache(filename, e)
+	}
+	if s.cache != nil {
+		s.storeCache(ctx, filename, e)
+	}
+	return e, nil
+}
+
+// unchanged reports if the size and modification time of filename match those
+// of its cached hash, for WithRevalidate.
+func (s *Server) unchanged(filename string, e *hashEntry) bool {
+	if e.derived || e.modTime.IsZero() || s.hasImageVariants(filename) {
+		return false
+	}
+	fi, err := fs.Stat(s.fs, filename)
+	return err == nil && fi.Size() == e.size && fi.ModTime().Equal(e.modTime)
+}
+
+// contextReader stops reading once the context is done.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
+
+// lastModified returns the Last-Modified time for a file modified at t, which
+// is zero if it should not be sent.
+func (s *Server) lastModified(t time.Time) time.Time {
+	if s.validators == ValidateETag {
+		return time.Time{}
+	}
+	if !s.modTime.IsZero() {
+		return s.modTime
+	}
+	return t
+}
+
+// entry returns the hashEntry for filename with the digest of its content.
+func (s *Server) entry(filename string, d Digest) *hashEntry {
+	e := &hashEntry{hash: d.Hash, digest: d.Sum}
+	ext := s.ext(filename)
+	switch {
+	case matchAny(s.exclude, filename):
+		e.path = filename
+	case s.contentAddressed:
+		e.hash = s.encoding.EncodeToString(d.Sum)
+		e.path = addressedPrefix + e.hash
+	case s.queryVersion:
+		e.path = filename + "?v=" + e.hash
+	case s.namer != nil:
+		e.path = s.namer.Name(filename[0:len(filename)-len(ext)], e.hash, ext)
+	default:
+		var b strings.Builder
+		b.Grow(len(filename) + len(e.hash) + 1)
+		b.WriteString(filename[0 : len(filename)-len(ext)])
+		b.WriteByte('.')
+		b.WriteString(e.hash)
+		b.WriteString(ext)
+		e.path = b.String()
+	}
+	return e
+}
+
+// copyBuffers are the buffers used to read files while hashing them.
+var copyBuffers = sync.Pool{
+	New: func() any {
+		b := make([]byte, 32*1024)
+		return &b
+	},
+}
+
+// Digest is the result of hashing content.
+type Digest struct {
+	Sum  []byte // the full digest
+	Hash string // the encoded and truncated hash embedded in hashed paths
+}
+
+// Encode returns the full digest encoded with the Encoding.
+func (d Digest) Encode(enc Encoding) string {
+	return enc.EncodeToString(d.Sum)
+}
+
+// HashReader hashes the content read from r using the default settings, which
+// is useful to fingerprint generated content using the same scheme as files.
+func HashReader(r io.Reader) (Digest, error) {
+	return defaultSettings.HashReader(r)
+}
+
+// defaultSettings is a Server used for its default settings.
+var defaultSettings = New(nil)
+
+// HashReader hashes the content read from r using the hash, encoding and hash
+// length of the Server, which is useful to fingerprint generated content using
+// the same scheme as files.
+func (s *Server) HashReader(r io.Reader) (Digest, error) {
+	h, _ := s.hashers.Get().(hash.Hash)
+	if h == nil {
+		h = s.newHash()
+	}
+	defer func() {
+		h.Reset()
+		s.hashers.Put(h)
+	}()
+	buf := copyBuffers.Get().(*[]byte)
+	defer copyBuffers.Put(buf)
+	if _, err := io.CopyBuffer(h, r, *buf); err != nil {
+		return Digest{}, err
+	}
+	return s.digest(h.Sum(nil)), nil
+}
+
+// digest returns the Digest for the full digest sum.
+func (s *Server) digest(sum []byte) Digest {
+	return Digest{
+		Sum:  sum,
+		Hash: s.encoding.EncodeToString(sum[:min(len(sum), s.hashLength)]),
 	}
+}
+
+// DigestPath returns the hashed path for filename with the digest of its
+// content, such as one returned by HashReader, using the naming scheme of the
+// Server.
+func (s *Server) DigestPath(filename string, d Digest) string {
+	return s.urlPrefix() + s.entry(filename, d).path
+}
 
-	var r io.Reader
-	if isCSSFilename(filename) {
-		content, err := hashCSSAssets(fs, filename)
-		if err == nil {
-			r = strings.NewReader(content)
+// Precompute hashes every file in the file system up front, avoiding the
+// hashing cost on the first request for each file.
+func Precompute(fs fs.FS) error {
+	return defaultServer(fs).Precompute()
+}
+
+// Precompute hashes every file in the file system up front, avoiding the
+// hashing cost on the first request for each file. Files are hashed in the
+// order configured by WithPriority, and the file configured by
+// WithHashCacheFile is written once done.
+func (s *Server) Precompute() error {
+	files, err := s.files(context.Background())
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if _, err := s.hash(f.name); err != nil {
+			return err
 		}
 	}
-	if r == nil {
-		f, err := fs.Open(filename)
+	if s.hashCache != nil {
+		return s.SaveHashCache()
+	}
+	return nil
+}
+
+// WithPriority configures the order Precompute and Warm hash files in, so
+// files matching earlier patterns, such as the entry bundles of the landing
+// page, are hashed before files matching later ones and those matching none.
+// Patterns use the same syntax as WithPassthrough.
+func WithPriority(patterns ...string) Option {
+	return func(s *Server) {
+		s.priority = append(s.priority, patterns...)
+	}
+}
+
+type walkedFile struct {
+	name string
+	d    fs.DirEntry
+}
+
+// files returns the files which are not hidden, in lexical order within the
+// priority order.
+func (s *Server) files(ctx context.Context) ([]walkedFile, error) {
+	var files []walkedFile
+	err := fs.WalkDir(s.fs, ".", func(name string, d fs.DirEntry, err error) error {
 		if err != nil {
-			return "", fmt.Errorf("hashfs: error opening file: %w", err)
+			return err
 		}
-		defer f.Close()
-		r = f
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !d.IsDir() && !s.isHidden(name) {
+			files = append(files, walkedFile{name: name, d: d})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(s.priority) > 0 {
+		rank := func(name string) int {
+			for i, pattern := range s.priority {
+				if matchAny([]string{pattern}, name) {
+					return i
+				}
+			}
+			return len(s.priority)
+		}
+		slices.SortStableFunc(files, func(a, b walkedFile) int {
+			return rank(a.name) - rank(b.name)
+		})
 	}
+	return files, nil
+}
 
-	h := sha256.New()
-	if _, err := io.Copy(h, r); err != nil {
-		return "", err
+// CheckPresence ensures the named files exist without reading or hashing them,
+// allowing typos to fail fast while deferring hashing to first use. It returns
+// an error for the first missing file.
+func CheckPresence(fsys fs.FS, names ...string) error {
+	for _, name := range names {
+		if _, err := fs.Stat(fsys, name); err != nil {
+			return fmt.Errorf("hashfs: missing file %q: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// ErrPathMismatch is returned by Unhashed when the hash in a path does not match
+// the current content of the file.
+var ErrPathMismatch = errors.New("hashfs: path mismatch")
+
+// ErrInvalidPath is returned for paths which are not valid file system paths,
+// such as those containing ".." elements, NUL bytes or backslashes, or
+// absolute paths. They are rejected before reaching the file system, which may
+// be less strict than required by fs.FS.
+var ErrInvalidPath = errors.New("hashfs: invalid path")
+
+// checkPath returns ErrInvalidPath if name is not a valid file system path. A
+// trailing slash is allowed for directories, and the empty path for the root.
+func checkPath(name string) error {
+	trimmed := strings.TrimSuffix(name, "/")
+	if trimmed == "" && name != "/" {
+		return nil
 	}
-	ext := filepath.Ext(filename)
-	hashBytes := h.Sum(nil)
-	newP := fmt.Sprintf("%s.%x%s", filename[0:len(filename)-len(ext)], hashBytes[:6], ext)
-	cachedHashes.Store(key, newP)
-	return newP, nil
+	if !validPath(trimmed) {
+		return fmt.Errorf("%w %q", ErrInvalidPath, name)
+	}
+	return nil
+}
+
+// validPath reports if name is valid according to fs.ValidPath, and does not
+// contain backslashes or NUL bytes.
+func validPath(name string) bool {
+	return fs.ValidPath(name) && !strings.ContainsAny(name, "\\\x00")
 }
 
 // Unhashed returns the original unhashed filename from a hashed path.
 // It will ensure the hash matches.
 func Unhashed(fs fs.FS, urlpath string) (string, error) {
+	return defaultServer(fs).Unhashed(urlpath)
+}
+
+// Unhashed returns the original unhashed filename from a hashed path.
+// It will ensure the hash matches.
+func (s *Server) Unhashed(urlpath string) (string, error) {
+	return s.unhashed(context.Background(), urlpath)
+}
+
+func (s *Server) unhashed(ctx context.Context, urlpath string) (string, error) {
+	if s.dev {
+		return urlpath, nil
+	}
+	filename := s.splitHashed(urlpath)
+	e, err := s.hashContext(ctx, filename)
+	if err != nil && s.encoding == Base32 && errors.Is(err, fs.ErrNotExist) {
+		if folded, ok := s.foldName(filename); ok {
+			filename = folded
+			e, err = s.hashContext(ctx, filename)
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+	expectedPath := e.path
+	if expectedPath != urlpath && !(s.encoding == Base32 && strings.EqualFold(expectedPath, urlpath)) {
+		return "", fmt.Errorf("%w for %q", ErrPathMismatch, urlpath)
+	}
+	return filename, nil
+}
+
+// foldName returns the name of the file matching filename case-insensitively,
+// preferring exact matches for each element.
+func (s *Server) foldName(filename string) (string, bool) {
+	if !validPath(filename) {
+		return "", false
+	}
+	dir := "."
+	for elem := range strings.SplitSeq(filename, "/") {
+		entries, err := fs.ReadDir(s.fs, dir)
+		if err != nil {
+			return "", false
+		}
+		match := ""
+		for _, d := range entries {
+			if d.Name() == elem {
+				match = elem
+				break
+			}
+			if match == "" && strings.EqualFold(d.Name(), elem) {
+				match = d.Name()
+			}
+		}
+		if match == "" {
+			return "", false
+		}
+		dir = path.Join(dir, match)
+	}
+	return dir, true
+}
+
+// splitHashed returns the filename for a hashed path, without verifying the
+// hash.
+func (s *Server) splitHashed(urlpath string) string {
+	if s.contentAddressed {
+		if filename, found := s.addressed.Load(urlpath); found {
+			return filename.(string)
+		}
+		return ""
+	}
+	if s.queryVersion {
+		filename, _, _ := strings.Cut(urlpath, "?")
+		return filename
+	}
+	if s.namer != nil {
+		filename, _ := s.namer.Parse(urlpath)
+		return filename
+	}
 	urlpathL := len(urlpath)
-	ext := filepath.Ext(urlpath)
+	ext := s.ext(urlpath)
 	extL := len(ext)
 	hash := filepath.Ext(urlpath[0 : urlpathL-extL])
 	if hash == "" { // assume extensionless, use ext as hash
@@ -235,13 +2091,5 @@ func Unhashed(fs fs.FS, urlpath string) (string, error) {
 		ext = ""
 		extL = 0
 	}
-	filename := urlpath[0:urlpathL-extL-len(hash)] + ext
-	expectedPath, err := MaybePath(fs, filename)
-	if err != nil {
-		return "", err
-	}
-	if expectedPath != urlpath {
-		return "", fmt.Errorf("hashfs: path mismatch for %q", urlpath)
-	}
-	return filename, nil
+	return urlpath[0:urlpathL-extL-len(hash)] + ext
 }
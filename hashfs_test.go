@@ -1,11 +1,31 @@
 package hashfs
 
 import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"crypto/sha256"
 	"embed"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"io/fs"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
 	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"testing/fstest"
+	"time"
 
 	"github.com/daaku/ensure"
 )
@@ -26,12 +46,183 @@ func TestValidPath(t *testing.T) {
 	ensure.DeepEqual(t, Path(assets, unhashedEmpty), hashedEmpty)
 }
 
+func TestHashDigest(t *testing.T) {
+	e, err := New(assets).hash(unhashedMainJS)
+	ensure.Nil(t, err)
+	content, err := assets.ReadFile(unhashedMainJS)
+	ensure.Nil(t, err)
+	digest := sha256.Sum256(content)
+	ensure.DeepEqual(t, e.digest, digest[:])
+	ensure.DeepEqual(t, e.path, hashedMainJS)
+	ensure.DeepEqual(t, e.hash, hex.EncodeToString(digest[:6]))
+}
+
+func TestHash(t *testing.T) {
+	cases := []struct {
+		hash   func() hash.Hash
+		hashed string
+	}{
+		{sha256.New, hashedMainJS},
+		{sha1.New, "assets/main.14da546811b1.js"},
+		{func() hash.Hash { return crc32.NewIEEE() }, "assets/main.2d6ee330.js"},
+	}
+	for _, c := range cases {
+		s := New(assets, WithHash(c.hash))
+		ensure.DeepEqual(t, s.Path(unhashedMainJS), c.hashed)
+		r := httptest.NewRequest("GET", "/"+c.hashed, nil)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		ensure.DeepEqual(t, w.Code, http.StatusOK)
+	}
+}
+
+func TestEncoding(t *testing.T) {
+	cases := []struct {
+		encoding Encoding
+		hashed   string
+	}{
+		{Hex, hashedMainJS},
+		{Base32, "assets/main.MB4X3NXI74.js"},
+		{Base64URL, "assets/main.YHl9tuj_.js"},
+	}
+	for _, c := range cases {
+		s := New(assets, WithEncoding(c.encoding))
+		ensure.DeepEqual(t, s.Path(unhashedMainJS), c.hashed)
+		filename, err := s.Unhashed(c.hashed)
+		ensure.Nil(t, err)
+		ensure.DeepEqual(t, filename, unhashedMainJS)
+	}
+}
+
+func TestHashLength(t *testing.T) {
+	cases := []struct {
+		length   int
+		encoding Encoding
+		hashed   string
+	}{
+		{4, Hex, "assets/main.60797db6.js"},
+		{0, Hex, "assets/main.60797db6.js"},
+		{-1, Hex, "assets/main.60797db6.js"},
+		{10, Base64URL, "assets/main.YHl9tuj_MtoXfw.js"},
+	}
+	for _, c := range cases {
+		s := New(assets, WithHashLength(c.length), WithEncoding(c.encoding))
+		ensure.DeepEqual(t, s.Path(unhashedMainJS), c.hashed)
+		filename, err := s.Unhashed(c.hashed)
+		ensure.Nil(t, err)
+		ensure.DeepEqual(t, filename, unhashedMainJS)
+		_, err = s.Unhashed(hashedMainJS)
+		ensure.Err(t, err, regexp.MustCompile("hashfs: path mismatch for"))
+	}
+}
+
+func TestBaseURL(t *testing.T) {
+	s := New(assets, WithBaseURL("https://cdn.example.com/static/"))
+	ensure.DeepEqual(t, s.Path(unhashedMainJS), "https://cdn.example.com/static/"+hashedMainJS)
+	filename, err := s.Unhashed(hashedMainJS)
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, filename, unhashedMainJS)
+	css, err := s.hashCSSAssets(context.Background(), "assets/sub/main.css")
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, css.content, "@import \"../boom.8d7a531d714c.css\";\n")
+}
+
+func TestDevMode(t *testing.T) {
+	s := New(assets, WithDevMode())
+	ensure.DeepEqual(t, s.Path(unhashedMainJS), unhashedMainJS)
+	_, err := s.MaybePath("assets/missing.js")
+	ensure.Err(t, err, regexp.MustCompile("hashfs: error opening file"))
+
+	r := httptest.NewRequest("GET", "/assets/main.css", nil)
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	ensure.DeepEqual(t, w.Code, http.StatusOK)
+	ensure.DeepEqual(t, w.Header().Get("Cache-Control"), "no-store")
+	ensure.DeepEqual(t, w.Header().Get("ETag"), "")
+	expected, err := assets.ReadFile("assets/main.css")
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, w.Body.Bytes(), expected)
+}
+
+func TestBase32Request(t *testing.T) {
+	s := New(assets, WithEncoding(Base32))
+	for _, p := range []string{s.Path(unhashedMainJS), "assets/main.mb4x3nxi74.js", "ASSETS/MAIN.MB4X3NXI74.JS"} {
+		r := httptest.NewRequest("GET", "/"+p, nil)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		ensure.DeepEqual(t, w.Code, http.StatusOK)
+		ensure.DeepEqual(t, w.Body.String(), "alert('hello world')\n")
+	}
+
+	filename, err := s.Unhashed("ASSETS/MAIN.MB4X3NXI74.JS")
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, filename, unhashedMainJS)
+
+	_, err = New(assets).Unhashed("assets/main.60797DB6E8FF.js")
+	ensure.Err(t, err, regexp.MustCompile("hashfs: path mismatch for"))
+}
+
+func TestInstances(t *testing.T) {
+	fsys := fstest.MapFS{"main.js": {Data: []byte("main")}}
+	hex := New(fsys)
+	b32 := New(fsys, WithEncoding(Base32))
+	ensure.DeepEqual(t, hex.Path("main.js"), "main.0d6e4079e367.js")
+	ensure.DeepEqual(t, b32.Path("main.js"), "main.BVXEA6PDM4.js")
+
+	fsys["main.js"] = &fstest.MapFile{Data: []byte("changed")}
+	ensure.DeepEqual(t, New(fsys).Path("main.js"), "main.d67e2e944994.js")
+	ensure.DeepEqual(t, hex.Path("main.js"), "main.0d6e4079e367.js")
+}
+
+type funcFS func(name string) (fs.File, error)
+
+func (f funcFS) Open(name string) (fs.File, error) {
+	return f(name)
+}
+
+func TestUncomparableFS(t *testing.T) {
+	fsys := fstest.MapFS{"main.js": {Data: []byte("main")}}
+	ensure.DeepEqual(t, Path(fsys, "main.js"), "main.0d6e4079e367.js")
+	ensure.True(t, defaultServer(fsys) == defaultServer(fsys))
+	other := fstest.MapFS{"main.js": {Data: []byte("changed")}}
+	ensure.DeepEqual(t, Path(other, "main.js"), "main.d67e2e944994.js")
+
+	// cached like any other file system
+	fsys["main.js"] = &fstest.MapFile{Data: []byte("changed")}
+	ensure.DeepEqual(t, Path(fsys, "main.js"), "main.0d6e4079e367.js")
+
+	// without a key, a func is hashed on every call
+	funcs := funcFS(fsys.Open)
+	ensure.DeepEqual(t, Path(funcs, "main.js"), "main.d67e2e944994.js")
+	ensure.False(t, defaultServer(funcs) == defaultServer(funcs))
+}
+
+func TestFileServerOptions(t *testing.T) {
+	ensure.True(t, FileServer(assets) == assetsH)
+	h := FileServer(assets, WithEncoding(Base32))
+	ensure.True(t, h != assetsH)
+	r := httptest.NewRequest("GET", "/"+New(assets, WithEncoding(Base32)).Path(unhashedMainJS), nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	ensure.DeepEqual(t, w.Code, http.StatusOK)
+}
+
 func TestInvalidPath(t *testing.T) {
 	p, err := MaybePath(assets, "foo")
 	ensure.DeepEqual(t, p, "")
 	ensure.Err(t, err, regexp.MustCompile("hashfs: error opening file"))
 }
 
+func TestNotExistErrors(t *testing.T) {
+	_, err := MaybePath(assets, "assets/missing.js")
+	ensure.True(t, errors.Is(err, fs.ErrNotExist))
+	_, err = Unhashed(assets, "assets/missing.000000000000.js")
+	ensure.True(t, errors.Is(err, fs.ErrNotExist))
+	_, err = Unhashed(assets, "assets/main.000000000000.js")
+	ensure.False(t, errors.Is(err, fs.ErrNotExist))
+	ensure.True(t, errors.Is(err, ErrPathMismatch))
+}
+
 func TestPathPanic(t *testing.T) {
 	defer func() {
 		ensure.Err(t, recover().(error), regexp.MustCompile("hashfs: error opening file"))
@@ -39,6 +230,55 @@ func TestPathPanic(t *testing.T) {
 	Path(assets, "foo")
 }
 
+func TestPrecompute(t *testing.T) {
+	s := New(assets)
+	ensure.Nil(t, s.Precompute())
+	var count int
+	s.hashes.Range(func(_, _ any) bool {
+		count++
+		return true
+	})
+	ensure.DeepEqual(t, count, 8)
+	ensure.Nil(t, Precompute(assets))
+
+	err := New(fstest.MapFS{"a": {Mode: fs.ModeSymlink}}).Precompute()
+	ensure.Err(t, err, regexp.MustCompile("invalid argument"))
+}
+
+func TestPrecomputePriority(t *testing.T) {
+	fsys := fstest.MapFS{
+		"a.txt":          {Data: []byte("a")},
+		"img/b.png":      {Data: []byte("b")},
+		"img/a.png":      {Data: []byte("a")},
+		"js/entry.js":    {Data: []byte("entry")},
+		"js/lazy.js":     {Data: []byte("lazy")},
+		"z/critical.css": {Data: []byte("critical")},
+	}
+	var order []string
+	observe := WithHashObserver(func(ctx context.Context, filename string, start time.Time, d time.Duration) {
+		order = append(order, filename)
+	})
+	s := New(fsys, observe, WithPriority("js/entry.js", "*.css"), WithPriority("*.txt"))
+	ensure.Nil(t, s.Precompute())
+	ensure.DeepEqual(t, order, []string{
+		"js/entry.js", "z/critical.css", "a.txt", "img/a.png", "img/b.png", "js/lazy.js",
+	})
+
+	order = nil
+	s = New(fsys, observe, WithPriority("img/*"))
+	ensure.Nil(t, s.Warm(context.Background(), nil).Wait())
+	ensure.DeepEqual(t, order, []string{
+		"img/a.png", "img/b.png", "a.txt", "js/entry.js", "js/lazy.js", "z/critical.css",
+	})
+}
+
+func TestCheckPresence(t *testing.T) {
+	ensure.Nil(t, CheckPresence(assets, unhashedMainJS, unhashedEmpty))
+	err := CheckPresence(assets, unhashedMainJS, "assets/missing.js", "assets/other.js")
+	ensure.Err(t, err, regexp.MustCompile(`hashfs: missing file "assets/missing.js"`))
+	ensure.True(t, errors.Is(err, fs.ErrNotExist))
+}
+
 func TestValidRequest(t *testing.T) {
 	cases := []struct {
 		unhashed, hashed string
@@ -62,25 +302,130 @@ func TestValidRequest(t *testing.T) {
 }
 
 func TestInvalidRequest(t *testing.T) {
+	var logged []error
+	verbose := New(assets, WithVerboseErrors(), WithErrorLog(func(r *http.Request, err error) {
+		logged = append(logged, err)
+	}))
 	cases := []struct {
 		path, err string
+		code      int
 	}{
-		{"/assets/main.js", "hashfs: error opening file"},
-		{"assets/main.000000000000.js", "hashfs: path mismatch for"},
+		{"/assets/main.js", "hashfs: invalid path", http.StatusBadRequest},
+		{"assets/missing.000000000000.js", "hashfs: error opening file", http.StatusNotFound},
+		{"assets/main.000000000000.js", "hashfs: path mismatch for", http.StatusGone},
 	}
 	for _, c := range cases {
 		r := httptest.NewRequest("GET", "/"+c.path, nil)
 		w := httptest.NewRecorder()
-		assetsH.ServeHTTP(w, r)
-		ensure.DeepEqual(t, w.Code, http.StatusBadRequest)
+		verbose.ServeHTTP(w, r)
+		ensure.DeepEqual(t, w.Code, c.code)
 		ensure.StringContains(t, w.Body.String(), c.err)
+
+		// the default does not leak details
+		w = httptest.NewRecorder()
+		assetsH.ServeHTTP(w, r)
+		ensure.DeepEqual(t, w.Code, c.code)
+		ensure.DeepEqual(t, w.Body.String(), http.StatusText(c.code)+"\n")
+	}
+	ensure.DeepEqual(t, len(logged), len(cases))
+	ensure.StringContains(t, logged[1].Error(), "hashfs: error opening file")
+}
+
+func TestRedirect(t *testing.T) {
+	h := http.StripPrefix("/static", New(assets, WithRedirect(http.StatusPermanentRedirect)))
+	cases := []struct {
+		path, location string
+		code           int
+	}{
+		{"/static/assets/main.js", "main.60797db6e8ff.js", http.StatusPermanentRedirect},
+		{"/static/assets/empty?v=1", "empty.e3b0c44298fc?v=1", http.StatusPermanentRedirect},
+		{"/static/assets/missing.js", "", http.StatusNotFound},
+		{"/static/" + hashedMainJS, "", http.StatusOK},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest("GET", c.path, nil)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		ensure.DeepEqual(t, w.Code, c.code)
+		ensure.DeepEqual(t, w.Header().Get("Location"), c.location)
+	}
+}
+
+func TestRedirectNamers(t *testing.T) {
+	cases := []struct {
+		opts     []Option
+		location string
+	}{
+		{[]Option{WithDirectoryHash()}, "60797db6e8ff/main.js?v=1"},
+		{[]Option{WithContentAddressed()}, "../_ca/60797db6e8ff32da177f208acb80a9fc6f747cfbbe90a111ea6a7256b512058f?v=1"},
+	}
+	for _, c := range cases {
+		opts := append([]Option{WithRedirect(http.StatusFound)}, c.opts...)
+		h := http.StripPrefix("/static", New(assets, opts...))
+		r := httptest.NewRequest("GET", "/static/assets/main.js?v=1", nil)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		ensure.DeepEqual(t, w.Code, http.StatusFound)
+		ensure.DeepEqual(t, w.Header().Get("Location"), c.location)
+
+		// the location resolves to the hashed path, without another redirect
+		location, err := r.URL.Parse(c.location)
+		ensure.Nil(t, err)
+		w = httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", location.String(), nil))
+		ensure.DeepEqual(t, w.Code, http.StatusOK)
+		ensure.DeepEqual(t, w.Body.String(), "alert('hello world')\n")
+	}
+}
+
+func TestUnhashed(t *testing.T) {
+	s := New(assets, WithUnhashed())
+	cases := []struct {
+		path, cacheControl string
+		code               int
+	}{
+		{unhashedMainJS, "no-cache", http.StatusOK},
+		{hashedMainJS, "public, immutable, max-age=31557600", http.StatusOK},
+		{"assets/main.000000000000.js", "", http.StatusGone},
+		{"assets/missing.js", "", http.StatusNotFound},
+		{"assets/fonts", "", http.StatusBadRequest},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest("GET", "/"+c.path, nil)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		ensure.DeepEqual(t, w.Code, c.code)
+		ensure.DeepEqual(t, w.Header().Get("Cache-Control"), c.cacheControl)
+		if c.code == http.StatusOK {
+			ensure.DeepEqual(t, w.Body.String(), "alert('hello world')\n")
+			ensure.DeepEqual(t, w.Header().Get("ETag"), `"60797db6e8ff"`)
+		}
+	}
+}
+
+func TestStaleGrace(t *testing.T) {
+	s := New(assets, WithStaleGrace(time.Minute))
+	cases := []struct {
+		path, cacheControl string
+		code               int
+	}{
+		{"assets/main.000000000000.js", "public, max-age=60", http.StatusOK},
+		{hashedMainJS, "public, immutable, max-age=31557600", http.StatusOK},
+		{"assets/missing.000000000000.js", "", http.StatusNotFound},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest("GET", "/"+c.path, nil)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		ensure.DeepEqual(t, w.Code, c.code)
+		ensure.DeepEqual(t, w.Header().Get("Cache-Control"), c.cacheControl)
 	}
 }
 
 func TestHashCSSAsset(t *testing.T) {
-	out, err := hashCSSAssets(assets, "assets/main.css")
+	out, err := New(assets).hashCSSAssets(context.Background(), "assets/main.css")
 	ensure.Nil(t, err)
-	ensure.DeepEqual(t, out,
+	ensure.DeepEqual(t, out.content,
 		`@font-face {
   font-family: "Foo";
   src:
@@ -95,12 +440,36 @@ func TestHashCSSAsset(t *testing.T) {
 }
 
 func TestHashCSSAssetSub(t *testing.T) {
-	out, err := hashCSSAssets(assets, "assets/sub/main.css")
+	out, err := New(assets).hashCSSAssets(context.Background(), "assets/sub/main.css")
 	ensure.Nil(t, err)
-	ensure.DeepEqual(t, out, `@import "../boom.8d7a531d714c.css";
+	ensure.DeepEqual(t, out.content, `@import "../boom.8d7a531d714c.css";
 `)
 }
 
+func TestHashCSSReferences(t *testing.T) {
+	fsys := fstest.MapFS{
+		"css/main.css": {Data: []byte(`@font-face {
+  src: url("font.woff2?v=1"), url(font.svg#icons), url(font.eot?#iefix);
+  background: url(data:image/png;base64,AAAA), url(/css/font.svg), url(https://example.com/a.png);
+}`)},
+		"css/font.woff2": {Data: []byte("woff2")},
+		"css/font.svg":   {Data: []byte("svg")},
+		"css/font.eot":   {Data: []byte("eot")},
+	}
+	s := New(fsys)
+	out, err := s.hashCSSAssets(context.Background(), "css/main.css")
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, out.content, `@font-face {
+  src: url("font.9aee6ac80dbc.woff2?v=1"), url(font.acdb1373d176.svg#icons), url(font.0ac329cdd431.eot?#iefix);
+  background: url(data:image/png;base64,AAAA), url(/css/font.svg), url(https://example.com/a.png);
+}`)
+
+	before := s.Path("css/main.css")
+	fsys["css/font.svg"] = &fstest.MapFile{Data: []byte("changed")}
+	s.Invalidate("css/font.svg")
+	ensure.NotDeepEqual(t, s.Path("css/main.css"), before)
+}
+
 func TestHashCSSRequest(t *testing.T) {
 	const unhashed = "assets/main.css"
 	const hashed = "assets/main.3b8e3d604b9f.css"
@@ -110,3 +479,1106 @@ func TestHashCSSRequest(t *testing.T) {
 	ensure.DeepEqual(t, w.Code, http.StatusOK)
 	ensure.DeepEqual(t, w.Header().Get("Content-Type"), "text/css; charset=utf-8")
 }
+
+func TestZipRequest(t *testing.T) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	files := map[string]string{
+		"main.js": "alert('hello world')",
+		"noext":   "hello world",
+	}
+	for name, content := range files {
+		w, err := zw.Create(name)
+		ensure.Nil(t, err)
+		_, err = w.Write([]byte(content))
+		ensure.Nil(t, err)
+	}
+	ensure.Nil(t, zw.Close())
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	ensure.Nil(t, err)
+
+	cases := []struct {
+		name, contentType string
+	}{
+		{"main.js", "text/javascript; charset=utf-8"},
+		{"noext", "text/plain; charset=utf-8"},
+	}
+	h := FileServer(zr)
+	for _, c := range cases {
+		r := httptest.NewRequest("GET", "/"+Path(zr, c.name), nil)
+		r.Header.Set("Range", "bytes=0-3")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		ensure.DeepEqual(t, w.Code, http.StatusOK)
+		ensure.DeepEqual(t, w.Header().Get("Content-Type"), c.contentType)
+		ensure.DeepEqual(t, w.Header().Get("Content-Length"), fmt.Sprint(len(files[c.name])))
+		ensure.DeepEqual(t, w.Body.String(), files[c.name])
+	}
+}
+
+func TestAccessRecorder(t *testing.T) {
+	var recorded []string
+	s := New(assets, WithAccessRecorder(func(name string) {
+		recorded = append(recorded, name)
+	}))
+	for _, p := range []string{hashedMainJS, "assets/main.000000000000.js", hashedEmpty} {
+		r := httptest.NewRequest("GET", "/"+p, nil)
+		s.ServeHTTP(httptest.NewRecorder(), r)
+	}
+	ensure.DeepEqual(t, recorded, []string{unhashedMainJS, unhashedEmpty})
+}
+
+func TestImageNegotiation(t *testing.T) {
+	fsys := fstest.MapFS{
+		"photo.png":  {Data: []byte("png")},
+		"photo.webp": {Data: []byte("webp")},
+		"logo.png":   {Data: []byte("logo")},
+	}
+	s := New(fsys, WithImageNegotiation("image/avif", "image/webp"))
+	cases := []struct {
+		name, accept, contentType, body, vary string
+	}{
+		{"photo.png", "image/avif,image/webp,*/*", "image/webp", "webp", "Accept-Encoding, Accept"},
+		{"photo.png", "image/webp;q=0, image/png", "image/png", "png", "Accept-Encoding, Accept"},
+		{"photo.png", "", "image/png", "png", "Accept-Encoding, Accept"},
+		{"logo.png", "image/webp", "image/png", "logo", "Accept-Encoding"},
+	}
+	etags := map[string]bool{}
+	for _, c := range cases {
+		r := httptest.NewRequest("GET", "/"+s.Path(c.name), nil)
+		r.Header.Set("Accept", c.accept)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		ensure.DeepEqual(t, w.Code, http.StatusOK)
+		ensure.DeepEqual(t, w.Header().Get("Content-Type"), c.contentType)
+		ensure.DeepEqual(t, w.Body.String(), c.body)
+		ensure.DeepEqual(t, strings.Join(w.Header().Values("Vary"), ", "), c.vary)
+		if etag := w.Header().Get("ETag"); c.vary != "Accept-Encoding" {
+			ensure.True(t, strings.HasPrefix(etag, `W/"`))
+			etags[c.body+etag] = true
+		}
+	}
+	ensure.DeepEqual(t, len(etags), 2)
+}
+
+func TestImageNegotiationVariantChange(t *testing.T) {
+	fsys := fstest.MapFS{
+		"photo.png":  {Data: []byte("png")},
+		"photo.webp": {Data: []byte("webp")},
+	}
+	s := New(fsys, WithImageNegotiation("image/webp"))
+	before := s.Path("photo.png")
+	ensure.NotDeepEqual(t, before, New(fsys).Path("photo.png"))
+
+	// the URL serving the variant changes with it
+	fsys["photo.webp"] = &fstest.MapFile{Data: []byte("new webp")}
+	s.Invalidate("photo.webp")
+	ensure.NotDeepEqual(t, s.Path("photo.png"), before)
+}
+
+func TestETag(t *testing.T) {
+	cases := []struct {
+		hashed, etag string
+	}{
+		{hashedMainJS, `"60797db6e8ff"`},
+		{"assets/main.3b8e3d604b9f.css", `"3b8e3d604b9f"`},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest("GET", "/"+c.hashed, nil)
+		w := httptest.NewRecorder()
+		assetsH.ServeHTTP(w, r)
+		ensure.DeepEqual(t, w.Code, http.StatusOK)
+		ensure.DeepEqual(t, w.Header().Get("ETag"), c.etag)
+
+		for _, inm := range []string{c.etag, `"other", ` + c.etag, "*"} {
+			r = httptest.NewRequest("GET", "/"+c.hashed, nil)
+			r.Header.Set("If-None-Match", inm)
+			w = httptest.NewRecorder()
+			assetsH.ServeHTTP(w, r)
+			ensure.DeepEqual(t, w.Code, http.StatusNotModified)
+			ensure.DeepEqual(t, w.Body.Len(), 0)
+		}
+
+		r = httptest.NewRequest("GET", "/"+c.hashed, nil)
+		r.Header.Set("If-None-Match", `"other"`)
+		w = httptest.NewRecorder()
+		assetsH.ServeHTTP(w, r)
+		ensure.DeepEqual(t, w.Code, http.StatusOK)
+	}
+}
+
+func TestMaxAge(t *testing.T) {
+	s := New(assets, WithMaxAge(24*time.Hour))
+	r := httptest.NewRequest("GET", "/"+hashedMainJS, nil)
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	ensure.DeepEqual(t, w.Code, http.StatusOK)
+	ensure.DeepEqual(t, w.Header().Get("Cache-Control"), "public, immutable, max-age=86400")
+}
+
+func TestCachePolicy(t *testing.T) {
+	s := New(fstest.MapFS{
+		"fonts/a.woff2":  {Data: []byte("font")},
+		"data/list.json": {Data: []byte("[]")},
+		"main.txt":       {Data: []byte("main")},
+	},
+		WithCachePolicy("public, max-age=60", "data/*.json"),
+		WithCachePolicy("public, max-age=600", "*.json", "*.woff2"),
+	)
+	cases := []struct {
+		filename, cacheControl string
+	}{
+		{"fonts/a.woff2", "public, max-age=600"},
+		{"data/list.json", "public, max-age=60"},
+		{"main.txt", "public, immutable, max-age=31557600"},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest("GET", "/"+s.Path(c.filename), nil)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		ensure.DeepEqual(t, w.Code, http.StatusOK)
+		ensure.DeepEqual(t, w.Header().Get("Cache-Control"), c.cacheControl)
+	}
+}
+
+func TestMaxAgeContext(t *testing.T) {
+	r := httptest.NewRequest("GET", "/"+hashedMainJS, nil)
+	w := httptest.NewRecorder()
+	assetsH.ServeHTTP(w, r)
+	ensure.DeepEqual(t, w.Header().Get("Cache-Control"), "public, immutable, max-age=31557600")
+
+	r = r.WithContext(WithMaxAgeContext(r.Context(), 5*time.Minute))
+	w = httptest.NewRecorder()
+	assetsH.ServeHTTP(w, r)
+	ensure.DeepEqual(t, w.Code, http.StatusOK)
+	ensure.DeepEqual(t, w.Header().Get("Cache-Control"), "private, immutable, max-age=300")
+}
+
+func TestDebugHeaders(t *testing.T) {
+	s := New(assets, WithDebugHeaders())
+	cases := []struct {
+		path, file string
+	}{
+		{hashedMainJS, unhashedMainJS},
+		{"assets/main.000000000000.js", ""},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest("GET", "/"+c.path, nil)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		ensure.DeepEqual(t, w.Header().Get("X-Hashfs-File"), c.file)
+	}
+
+	r := httptest.NewRequest("GET", "/"+hashedMainJS, nil)
+	w := httptest.NewRecorder()
+	assetsH.ServeHTTP(w, r)
+	ensure.DeepEqual(t, w.Header().Get("X-Hashfs-File"), "")
+}
+
+func TestContentTypeFunc(t *testing.T) {
+	fsys := fstest.MapFS{
+		"api/data.txt": {Data: []byte(`{"a":1}`)},
+		"notes.txt":    {Data: []byte("notes")},
+	}
+	s := New(fsys, WithContentTypeFunc(func(name string) string {
+		if strings.HasPrefix(name, "api/") {
+			return "application/json"
+		}
+		return ""
+	}))
+	cases := []struct {
+		name, contentType string
+	}{
+		{"api/data.txt", "application/json"},
+		{"notes.txt", "text/plain; charset=utf-8"},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest("GET", "/"+s.Path(c.name), nil)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		ensure.DeepEqual(t, w.Code, http.StatusOK)
+		ensure.DeepEqual(t, w.Header().Get("Content-Type"), c.contentType)
+	}
+}
+
+func TestContentTypes(t *testing.T) {
+	fsys := fstest.MapFS{
+		"site.webmanifest": {Data: []byte(`{}`)},
+		"app.MJS":          {Data: []byte("export {}")},
+		"LICENSE":          {Data: []byte("license")},
+		"data.bin":         {Data: []byte{0, 1, 2}},
+		"notes.txt":        {Data: []byte("notes")},
+	}
+	s := New(fsys,
+		WithContentTypes(map[string]string{
+			".webmanifest": "application/manifest+json",
+			".mjs":         "text/javascript; charset=utf-8",
+		}),
+		WithUnknownContentType(func(name string) string {
+			if path.Ext(name) == "" {
+				return "text/plain; charset=utf-8"
+			}
+			return ""
+		}),
+	)
+	cases := []struct {
+		name, contentType string
+	}{
+		{"site.webmanifest", "application/manifest+json"},
+		{"app.MJS", "text/javascript; charset=utf-8"},
+		{"LICENSE", "text/plain; charset=utf-8"},
+		{"data.bin", "application/octet-stream"},
+		{"notes.txt", "text/plain; charset=utf-8"},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest("GET", "/"+s.Path(c.name), nil)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		ensure.DeepEqual(t, w.Code, http.StatusOK)
+		ensure.DeepEqual(t, w.Header().Get("Content-Type"), c.contentType)
+	}
+}
+
+func TestCharset(t *testing.T) {
+	fsys := fstest.MapFS{
+		"main.css":  {Data: []byte("a {}")},
+		"icon.svg":  {Data: []byte("<svg></svg>")},
+		"data.json": {Data: []byte(`{}`)},
+		"image.png": {Data: []byte("png")},
+		"data.bin":  {Data: []byte{0, 1, 2}},
+	}
+	s := New(fsys, WithCharset("iso-8859-1"))
+	cases := []struct {
+		name, contentType string
+	}{
+		{"main.css", "text/css; charset=iso-8859-1"},
+		{"icon.svg", "image/svg+xml; charset=iso-8859-1"},
+		{"data.json", "application/json; charset=iso-8859-1"},
+		{"image.png", "image/png"},
+		{"data.bin", "application/octet-stream"},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest("GET", "/"+s.Path(c.name), nil)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		ensure.DeepEqual(t, w.Code, http.StatusOK)
+		ensure.DeepEqual(t, w.Header().Get("Content-Type"), c.contentType)
+	}
+}
+
+func TestRangeVariants(t *testing.T) {
+	fsys := fstest.MapFS{
+		"main.txt":   {Data: []byte("main")},
+		"pre.txt":    {Data: []byte("pre")},
+		"pre.txt.gz": {Data: []byte("pre gz")},
+		"main.css":   {Data: []byte(`a{background:url(a.png)}`)},
+		"a.png":      {Data: []byte("png")},
+	}
+	cases := []struct {
+		opts           []Option
+		name, encoding string
+		ifRange        bool
+		code           int
+		body           string
+	}{
+		{nil, "main.txt", "", false, http.StatusPartialContent, "ai"},
+		{nil, "main.txt", "", true, http.StatusPartialContent, "ai"},
+		{[]Option{WithContentCache(1024)}, "main.txt", "", true, http.StatusPartialContent, "ai"},
+		{[]Option{WithContentCache(1024)}, "pre.txt", "gzip", true, http.StatusPartialContent, "re"},
+		{nil, "main.css", "", true, http.StatusPartialContent, "{b"},
+		{[]Option{WithCompression()}, "main.css", "", true, http.StatusPartialContent, "{b"},
+	}
+	for _, c := range cases {
+		s := New(fsys, c.opts...)
+		r := httptest.NewRequest("GET", "/"+s.Path(c.name), nil)
+		r.Header.Set("Range", "bytes=1-2")
+		if c.encoding != "" {
+			r.Header.Set("Accept-Encoding", c.encoding)
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		etag := w.Header().Get("ETag")
+		ensure.DeepEqual(t, w.Code, c.code)
+		ensure.DeepEqual(t, w.Body.String(), c.body)
+		if !c.ifRange {
+			continue
+		}
+
+		// a matching If-Range serves the range, a stale one the full content
+		r.Header.Set("If-Range", etag)
+		w = httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		ensure.DeepEqual(t, w.Code, http.StatusPartialContent)
+		ensure.DeepEqual(t, w.Body.String(), c.body)
+		r.Header.Set("If-Range", `"stale"`)
+		w = httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		ensure.DeepEqual(t, w.Code, http.StatusOK)
+		ensure.True(t, w.Body.Len() > 2)
+	}
+}
+
+func TestLastModified(t *testing.T) {
+	modTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	build := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	fsys := fstest.MapFS{
+		"main.txt":  {Data: []byte("main"), ModTime: modTime},
+		"main.css":  {Data: []byte(`@import "main.txt";`), ModTime: modTime},
+		"plain.css": {Data: []byte("a {}"), ModTime: modTime},
+	}
+	cases := []struct {
+		opts         []Option
+		name         string
+		lastModified time.Time
+	}{
+		{nil, "main.txt", modTime},
+		{nil, "main.css", time.Time{}},
+		{nil, "plain.css", modTime},
+		{[]Option{WithContentCache(1024)}, "main.txt", modTime},
+		{[]Option{WithModTime(build)}, "main.txt", build},
+		{[]Option{WithModTime(build)}, "main.css", build},
+	}
+	for _, c := range cases {
+		s := New(fsys, c.opts...)
+		r := httptest.NewRequest("GET", "/"+s.Path(c.name), nil)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		ensure.DeepEqual(t, w.Code, http.StatusOK)
+		if c.lastModified.IsZero() {
+			ensure.DeepEqual(t, w.Header().Get("Last-Modified"), "")
+			continue
+		}
+		ensure.DeepEqual(t, w.Header().Get("Last-Modified"), c.lastModified.Format(http.TimeFormat))
+
+		r.Header.Set("If-Modified-Since", c.lastModified.Format(http.TimeFormat))
+		w = httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		ensure.DeepEqual(t, w.Code, http.StatusNotModified)
+
+		r.Header.Set("If-Modified-Since", c.lastModified.Add(-time.Hour).Format(http.TimeFormat))
+		w = httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		ensure.DeepEqual(t, w.Code, http.StatusOK)
+	}
+}
+
+func TestLastModifiedEmbed(t *testing.T) {
+	build := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	s := New(assets, WithModTime(build))
+	r := httptest.NewRequest("GET", "/"+hashedMainJS, nil)
+	r.Header.Set("If-Modified-Since", build.Format(http.TimeFormat))
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	ensure.DeepEqual(t, w.Code, http.StatusNotModified)
+}
+
+func TestValidators(t *testing.T) {
+	modTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	fsys := fstest.MapFS{"main.txt": {Data: []byte("main"), ModTime: modTime}}
+	etag, lastModified := `"0d6e4079e367"`, modTime.Format(http.TimeFormat)
+	stale := modTime.Add(-time.Hour).Format(http.TimeFormat)
+	cases := []struct {
+		validators                   Validators
+		etag, lastModified           string
+		ifNoneMatch, ifModifiedSince string
+		code                         int
+	}{
+		{ValidateBoth, etag, lastModified, etag, "", http.StatusNotModified},
+		{ValidateBoth, etag, lastModified, "", lastModified, http.StatusNotModified},
+		{ValidateBoth, etag, lastModified, `"other"`, lastModified, http.StatusOK},
+		{ValidateBoth, etag, lastModified, etag, stale, http.StatusNotModified},
+		{ValidateETag, etag, "", etag, "", http.StatusNotModified},
+		{ValidateETag, etag, "", "", lastModified, http.StatusOK},
+		{ValidateLastModified, "", lastModified, "", lastModified, http.StatusNotModified},
+		{ValidateLastModified, "", lastModified, "", stale, http.StatusOK},
+	}
+	for _, c := range cases {
+		s := New(fsys, WithValidators(c.validators))
+		r := httptest.NewRequest("GET", "/"+s.Path("main.txt"), nil)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		ensure.DeepEqual(t, w.Code, http.StatusOK)
+		ensure.DeepEqual(t, w.Header().Get("ETag"), c.etag)
+		ensure.DeepEqual(t, w.Header().Get("Last-Modified"), c.lastModified)
+
+		if c.ifNoneMatch != "" {
+			r.Header.Set("If-None-Match", c.ifNoneMatch)
+		}
+		if c.ifModifiedSince != "" {
+			r.Header.Set("If-Modified-Since", c.ifModifiedSince)
+		}
+		w = httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		ensure.DeepEqual(t, w.Code, c.code)
+	}
+}
+
+func TestEscapedPaths(t *testing.T) {
+	fsys := fstest.MapFS{
+		"my file.txt": {Data: []byte("main")},
+		"é/a+b.txt":   {Data: []byte("main")},
+		"main.css":    {Data: []byte(`a{background:url(my%20file.txt)}`)},
+	}
+	for _, opts := range [][]Option{nil, {WithPrefix("/static/")}, {WithContentCache(1024)}} {
+		s := New(fsys, opts...)
+		cases := []struct {
+			filename, escaped string
+		}{
+			{"my file.txt", "my%20file.0d6e4079e367.txt"},
+			{"é/a+b.txt", "%C3%A9/a+b.0d6e4079e367.txt"},
+		}
+		for _, c := range cases {
+			escaped, err := s.EscapedPath(c.filename)
+			ensure.Nil(t, err)
+			ensure.DeepEqual(t, escaped, s.urlPrefix()+c.escaped)
+			target := escaped
+			if !strings.HasPrefix(target, "/") {
+				target = "/" + target
+			}
+			r := httptest.NewRequest("GET", target, nil)
+			w := httptest.NewRecorder()
+			s.ServeHTTP(w, r)
+			ensure.DeepEqual(t, w.Code, http.StatusOK)
+			ensure.DeepEqual(t, w.Body.String(), "main")
+		}
+
+		r := httptest.NewRequest("GET", "/"+strings.TrimPrefix(s.Path("main.css"), "/"), nil)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		ensure.DeepEqual(t, w.Code, http.StatusOK)
+		ensure.DeepEqual(t, w.Body.String(), `a{background:url(my%20file.0d6e4079e367.txt)}`)
+	}
+}
+
+func TestHidden(t *testing.T) {
+	fsys := fstest.MapFS{
+		"main.js":               {Data: []byte("main")},
+		"main.js.map":           {Data: []byte("map")},
+		".DS_Store":             {Data: []byte("store")},
+		".git/config":           {Data: []byte("config")},
+		"sub/.main.js.swp":      {Data: []byte("swap")},
+		".well-known/security":  {Data: []byte("security")},
+		"robots.txt":            {Data: []byte("robots")},
+		"sub/.hidden/index.txt": {Data: []byte("index")},
+	}
+	s := New(fsys,
+		WithHideDotfiles(),
+		WithHidden("*.map"),
+		WithUnhashed(),
+	)
+	for _, name := range []string{"main.js.map", ".DS_Store", ".git/config", "sub/.main.js.swp", "sub/.hidden/index.txt"} {
+		_, err := s.MaybePath(name)
+		ensure.True(t, errors.Is(err, fs.ErrNotExist), name)
+		r := httptest.NewRequest("GET", "/"+name, nil)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		ensure.DeepEqual(t, w.Code, http.StatusNotFound, name)
+	}
+	for _, name := range []string{"main.js", ".well-known/security", "robots.txt"} {
+		r := httptest.NewRequest("GET", "/"+s.Path(name), nil)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		ensure.DeepEqual(t, w.Code, http.StatusOK, name)
+	}
+	manifest, err := s.Manifest()
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, len(manifest), 3)
+}
+
+func TestMalformedPath(t *testing.T) {
+	fsys := fstest.MapFS{"main.txt": {Data: []byte("main")}}
+	for _, opts := range [][]Option{{WithVerboseErrors()}, {WithDevMode()}, {WithUnhashed(), WithVerboseErrors()}} {
+		s := New(fsys, opts...)
+		for _, p := range []string{"/../main.txt", "/a/../main.txt", "//main.txt", "/main.txt\\..", "/main\x00.txt", "/./main.txt", "/a//main.txt"} {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.URL.Path = p
+			w := httptest.NewRecorder()
+			s.ServeHTTP(w, r)
+			ensure.DeepEqual(t, w.Code, http.StatusBadRequest, p)
+			ensure.StringContains(t, w.Body.String(), "hashfs: invalid path")
+		}
+	}
+
+	s := New(fsys)
+	for _, name := range []string{"../main.txt", "/main.txt", "a\\main.txt", "main.txt\x00", ""} {
+		_, err := s.MaybePath(name)
+		ensure.True(t, errors.Is(err, ErrInvalidPath), name)
+		ensure.True(t, errors.Is(err, fs.ErrNotExist), name)
+	}
+}
+
+func TestStaleStatus(t *testing.T) {
+	cases := []struct {
+		opts []Option
+		code int
+	}{
+		{nil, http.StatusGone},
+		{[]Option{WithStaleStatus(http.StatusBadRequest)}, http.StatusBadRequest},
+		{[]Option{WithStaleStatus(http.StatusNotFound)}, http.StatusNotFound},
+	}
+	for _, c := range cases {
+		s := New(assets, c.opts...)
+		r := httptest.NewRequest("GET", "/assets/main.000000000000.js", nil)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		ensure.DeepEqual(t, w.Code, c.code)
+	}
+}
+
+type countingFS struct {
+	fs.FS
+	opens atomic.Int64
+}
+
+func (c *countingFS) Open(name string) (fs.File, error) {
+	c.opens.Add(1)
+	time.Sleep(10 * time.Millisecond)
+	return c.FS.Open(name)
+}
+
+func TestConcurrentHash(t *testing.T) {
+	fsys := &countingFS{FS: fstest.MapFS{"main.txt": {Data: []byte("main")}}}
+	s := New(fsys)
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Go(func() {
+			ensure.DeepEqual(t, s.Path("main.txt"), "main.0d6e4079e367.txt")
+		})
+	}
+	wg.Wait()
+	ensure.DeepEqual(t, fsys.opens.Load(), int64(1))
+}
+
+func TestCSSUnterminatedString(t *testing.T) {
+	for _, css := range []string{`@import "`, `@import "a.txt`} {
+		s := New(fstest.MapFS{
+			"main.css": {Data: []byte(css)},
+			"a.txt":    {Data: []byte("a")},
+		})
+		_, err := s.MaybePath("main.css")
+		ensure.Nil(t, err)
+		r, err := s.hashCSSAssets(context.Background(), "main.css")
+		ensure.Nil(t, err)
+		ensure.False(t, r.ok)
+		ensure.DeepEqual(t, r.content, css)
+	}
+}
+
+func TestReferenceCycle(t *testing.T) {
+	fsys := fstest.MapFS{
+		"self.css": {Data: []byte(`@import "self.css";`)},
+		"a.css":    {Data: []byte(`@import "b.css";`)},
+		"b.css":    {Data: []byte(`@import "a.css";`)},
+		"self.js":  {Data: []byte("main\n//# sourceMappingURL=self.js")},
+	}
+	s := New(fsys)
+	ensure.StringContains(t, s.Path("self.css"), "self.")
+	content, _ := s.rewritten("self.css")
+	ensure.DeepEqual(t, content, `@import "self.css";`)
+
+	ensure.StringContains(t, s.Path("a.css"), "a.")
+	content, _ = s.rewritten("a.css")
+	ensure.DeepEqual(t, content, `@import "`+s.Path("b.css")+`";`)
+	content, _ = s.rewritten("b.css")
+	ensure.DeepEqual(t, content, `@import "a.css";`)
+
+	ensure.StringContains(t, s.Path("self.js"), "self.")
+
+	// files of a cycle hashed concurrently do not wait on each other
+	for range 10 {
+		s := New(&countingFS{FS: fsys})
+		var wg sync.WaitGroup
+		for _, name := range []string{"a.css", "b.css"} {
+			wg.Go(func() {
+				ensure.StringContains(t, s.Path(name), strings.TrimSuffix(name, ".css")+".")
+			})
+		}
+		wg.Wait()
+	}
+}
+
+// slowFS reads files one byte per millisecond.
+type slowFS struct {
+	fs.FS
+}
+
+func (s slowFS) Open(name string) (fs.File, error) {
+	f, err := s.FS.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return slowFile{f}, nil
+}
+
+type slowFile struct {
+	fs.File
+}
+
+func (f slowFile) Read(p []byte) (int, error) {
+	time.Sleep(time.Millisecond)
+	return f.File.Read(p[:min(len(p), 1)])
+}
+
+func TestMaybePathContext(t *testing.T) {
+	fsys := slowFS{fstest.MapFS{"large.txt": {Data: bytes.Repeat([]byte("a"), 200)}}}
+	s := New(fsys)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := s.MaybePathContext(ctx, "large.txt")
+	ensure.True(t, errors.Is(err, context.DeadlineExceeded))
+	ensure.True(t, time.Since(start) < 150*time.Millisecond)
+
+	// a waiting caller is not affected by the first caller being canceled
+	ctx, cancel = context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	wg.Go(func() {
+		_, err := s.MaybePathContext(ctx, "large.txt")
+		ensure.True(t, errors.Is(err, context.Canceled))
+	})
+	time.Sleep(5 * time.Millisecond)
+	wg.Go(func() {
+		p, err := s.MaybePathContext(context.Background(), "large.txt")
+		ensure.Nil(t, err)
+		ensure.DeepEqual(t, p, "large.c2a908d98f5d.txt")
+	})
+	time.Sleep(5 * time.Millisecond)
+	cancel()
+	wg.Wait()
+}
+
+// concurrentFS tracks the maximum number of files read at the same time.
+type concurrentFS struct {
+	fs.FS
+	current, max atomic.Int64
+}
+
+func (c *concurrentFS) Open(name string) (fs.File, error) {
+	f, err := c.FS.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &concurrentFile{File: f, fs: c}, nil
+}
+
+type concurrentFile struct {
+	fs.File
+	fs *concurrentFS
+}
+
+func (f *concurrentFile) Read(p []byte) (int, error) {
+	n := f.fs.current.Add(1)
+	for {
+		m := f.fs.max.Load()
+		if n <= m || f.fs.max.CompareAndSwap(m, n) {
+			break
+		}
+	}
+	time.Sleep(time.Millisecond)
+	f.fs.current.Add(-1)
+	return f.File.Read(p)
+}
+
+func TestHashConcurrency(t *testing.T) {
+	mapFS := fstest.MapFS{}
+	for i := range 10 {
+		mapFS[fmt.Sprintf("%d.txt", i)] = &fstest.MapFile{Data: []byte{byte(i)}}
+	}
+	for _, limit := range []int{1, 3} {
+		fsys := &concurrentFS{FS: mapFS}
+		s := New(fsys, WithHashConcurrency(limit))
+		var wg sync.WaitGroup
+		for i := range 10 {
+			wg.Go(func() {
+				_, err := s.MaybePath(fmt.Sprintf("%d.txt", i))
+				ensure.Nil(t, err)
+			})
+		}
+		wg.Wait()
+		ensure.True(t, fsys.max.Load() <= int64(limit))
+	}
+
+	// rewriting hashes referenced files while holding the limit
+	ensure.DeepEqual(t, New(assets, WithHashConcurrency(1)).Path("assets/main.css"), Path(assets, "assets/main.css"))
+
+	// queued callers give up when their context is done
+	fsys := slowFS{fstest.MapFS{
+		"a.txt": {Data: bytes.Repeat([]byte("a"), 100)},
+		"b.txt": {Data: []byte("b")},
+	}}
+	s := New(fsys, WithHashConcurrency(1))
+	go s.MaybePath("a.txt")
+	time.Sleep(10 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := s.MaybePathContext(ctx, "b.txt")
+	ensure.True(t, errors.Is(err, context.DeadlineExceeded))
+}
+
+func TestNegativeCache(t *testing.T) {
+	fsys := &countingFS{FS: fstest.MapFS{}}
+	s := New(fsys, WithNegativeCache(50*time.Millisecond))
+	for range 2 {
+		_, err := s.MaybePath("missing.txt")
+		ensure.True(t, errors.Is(err, fs.ErrNotExist))
+	}
+	ensure.DeepEqual(t, fsys.opens.Load(), int64(1))
+
+	time.Sleep(60 * time.Millisecond)
+	_, err := s.MaybePath("missing.txt")
+	ensure.True(t, errors.Is(err, fs.ErrNotExist))
+	ensure.DeepEqual(t, fsys.opens.Load(), int64(2))
+
+	s.Invalidate("missing.txt")
+	_, err = s.MaybePath("missing.txt")
+	ensure.True(t, errors.Is(err, fs.ErrNotExist))
+	ensure.DeepEqual(t, fsys.opens.Load(), int64(3))
+}
+
+func TestCompoundExtensions(t *testing.T) {
+	fsys := fstest.MapFS{
+		"app.min.js":     {Data: []byte("app")},
+		"archive.tar.gz": {Data: []byte("archive")},
+		"main.js":        {Data: []byte("main")},
+	}
+	s := New(fsys, WithCompoundExtensions(CompoundExtensions...))
+	cases := []struct {
+		filename, hashed string
+	}{
+		{"app.min.js", "app.a172cedcae47.min.js"},
+		{"archive.tar.gz", "archive.0eb3e36bfb24.tar.gz"},
+		{"main.js", "main.0d6e4079e367.js"},
+	}
+	for _, c := range cases {
+		ensure.DeepEqual(t, s.Path(c.filename), c.hashed)
+		filename, err := s.Unhashed(c.hashed)
+		ensure.Nil(t, err)
+		ensure.DeepEqual(t, filename, c.filename)
+	}
+}
+
+func TestQueryVersion(t *testing.T) {
+	fsys := fstest.MapFS{"main.txt": {Data: []byte("main")}}
+	s := New(fsys, WithQueryVersion())
+	hashed := s.Path("main.txt")
+	ensure.DeepEqual(t, hashed, "main.txt?v=0d6e4079e367")
+	filename, err := s.Unhashed(hashed)
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, filename, "main.txt")
+
+	cases := []struct {
+		path, cacheControl string
+		code               int
+	}{
+		{"/" + hashed, "public, immutable, max-age=31557600", http.StatusOK},
+		{"/main.txt", "no-cache", http.StatusOK},
+		{"/main.txt?v=000000000000", "no-cache", http.StatusOK},
+		{"/missing.txt?v=0d6e4079e367", "", http.StatusNotFound},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest("GET", c.path, nil)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		ensure.DeepEqual(t, w.Code, c.code)
+		ensure.DeepEqual(t, w.Header().Get("Cache-Control"), c.cacheControl)
+	}
+
+	m := New(fsys, WithQueryVersion())
+	ensure.Nil(t, m.LoadManifest(strings.NewReader(`{"main.txt": "main.txt?v=abcdef"}`)))
+	ensure.DeepEqual(t, m.Path("main.txt"), "main.txt?v=abcdef")
+}
+
+func TestPassthrough(t *testing.T) {
+	fsys := fstest.MapFS{
+		"robots.txt":           {Data: []byte("robots")},
+		"sub/favicon.ico":      {Data: []byte("icon")},
+		".well-known/security": {Data: []byte("security")},
+		".well-known/a/b":      {Data: []byte("nested")},
+		"main.txt":             {Data: []byte("main")},
+	}
+	s := New(fsys,
+		WithPassthrough("public, max-age=3600", "robots.txt", "favicon.ico"),
+		WithPassthrough("no-cache", ".well-known/*"),
+	)
+	cases := []struct {
+		path, cacheControl string
+		code               int
+	}{
+		{"/robots.txt", "public, max-age=3600", http.StatusOK},
+		{"/sub/favicon.ico", "public, max-age=3600", http.StatusOK},
+		{"/.well-known/security", "no-cache", http.StatusOK},
+		{"/.well-known/a/b", "", http.StatusGone},
+		{"/main.txt", "", http.StatusNotFound},
+		{"/" + s.Path("main.txt"), "public, immutable, max-age=31557600", http.StatusOK},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest("GET", c.path, nil)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		ensure.DeepEqual(t, w.Code, c.code)
+		ensure.DeepEqual(t, w.Header().Get("Cache-Control"), c.cacheControl)
+	}
+}
+
+func TestExclude(t *testing.T) {
+	fsys := fstest.MapFS{
+		"sw.js":       {Data: []byte("sw")},
+		"main.js":     {Data: []byte("main")},
+		"docs/a.html": {Data: []byte("a")},
+	}
+	s := New(fsys, WithExclude("*.html", "sw.js"))
+	ensure.DeepEqual(t, s.Path("sw.js"), "sw.js")
+	ensure.DeepEqual(t, s.Path("docs/a.html"), "docs/a.html")
+	ensure.DeepEqual(t, s.Path("main.js"), "main.0d6e4079e367.js")
+
+	cases := []struct {
+		path, cacheControl string
+		code               int
+	}{
+		{"/sw.js", "no-cache", http.StatusOK},
+		{"/docs/a.html", "no-cache", http.StatusOK},
+		{"/main.js", "", http.StatusNotFound},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest("GET", c.path, nil)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		ensure.DeepEqual(t, w.Code, c.code)
+		ensure.DeepEqual(t, w.Header().Get("Cache-Control"), c.cacheControl)
+	}
+
+	m := New(fsys, WithExclude("sw.js"))
+	ensure.Nil(t, m.LoadManifest(strings.NewReader(`{"sw.js": "sw.js"}`)))
+	ensure.DeepEqual(t, m.Path("sw.js"), "sw.js")
+}
+
+func TestFallback(t *testing.T) {
+	fsys := fstest.MapFS{
+		"index.html": {Data: []byte("index")},
+		"main.js":    {Data: []byte("main")},
+	}
+	s := New(fsys, WithFallback("index.html"))
+	cases := []struct {
+		path, body, cacheControl string
+		code                     int
+	}{
+		{"/users/123", "index", "no-cache", http.StatusOK},
+		{"/missing.000000000000.js", "index", "no-cache", http.StatusOK},
+		{"/" + s.Path("main.js"), "main", "public, immutable, max-age=31557600", http.StatusOK},
+		{"/main.000000000000.js", "", "", http.StatusGone},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest("GET", c.path, nil)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		ensure.DeepEqual(t, w.Code, c.code)
+		ensure.DeepEqual(t, w.Header().Get("Cache-Control"), c.cacheControl)
+		if c.code == http.StatusOK {
+			ensure.DeepEqual(t, w.Body.String(), c.body)
+		}
+	}
+}
+
+func TestIndex(t *testing.T) {
+	fsys := fstest.MapFS{
+		"index.html":        {Data: []byte("index")},
+		"docs/index.html":   {Data: []byte("docs")},
+		"other/default.htm": {Data: []byte("default")},
+	}
+	cases := []struct {
+		opts []Option
+		path string
+		code int
+		body string
+	}{
+		{nil, "/", http.StatusOK, "index"},
+		{nil, "/docs/", http.StatusOK, "docs"},
+		{nil, "/other/", http.StatusNotFound, ""},
+		{[]Option{WithIndex("default.htm", "index.html")}, "/other/", http.StatusOK, "default"},
+		{[]Option{WithIndex()}, "/", http.StatusNotFound, ""},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest("GET", c.path, nil)
+		w := httptest.NewRecorder()
+		New(fsys, c.opts...).ServeHTTP(w, r)
+		ensure.DeepEqual(t, w.Code, c.code)
+		if c.code == http.StatusOK {
+			ensure.DeepEqual(t, w.Body.String(), c.body)
+			ensure.DeepEqual(t, w.Header().Get("Cache-Control"), "no-cache")
+		}
+	}
+}
+
+func TestErrorHandler(t *testing.T) {
+	s := New(assets, WithErrorHandler(func(w http.ResponseWriter, r *http.Request, err error) {
+		if errors.Is(err, fs.ErrNotExist) {
+			w.WriteHeader(http.StatusGone)
+			return
+		}
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	cases := []struct {
+		path string
+		code int
+	}{
+		{"/assets/missing.000000000000.js", http.StatusGone},
+		{"/assets/main.000000000000.js", http.StatusTeapot},
+		{"/" + hashedMainJS, http.StatusOK},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest("GET", c.path, nil)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		ensure.DeepEqual(t, w.Code, c.code)
+	}
+}
+
+func TestHeaderFunc(t *testing.T) {
+	s := New(assets, WithHeaderFunc(func(w http.ResponseWriter, r *http.Request, name string, info fs.FileInfo) {
+		w.Header().Set("X-File", fmt.Sprintf("%s %d", name, info.Size()))
+	}))
+	r := httptest.NewRequest("GET", "/"+hashedMainJS, nil)
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	ensure.DeepEqual(t, w.Code, http.StatusOK)
+	ensure.DeepEqual(t, w.Header().Get("X-File"), fmt.Sprintf("%s %d", unhashedMainJS, w.Body.Len()))
+}
+
+func TestHashReader(t *testing.T) {
+	d, err := HashReader(strings.NewReader("main"))
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, d.Hash, "0d6e4079e367")
+	ensure.DeepEqual(t, d.Encode(Hex), "0d6e4079e36703ebd37c00722f5891d28b0e2811dc114b129215123adcce3605")
+
+	s := New(fstest.MapFS{"main.js": {Data: []byte("main")}}, WithEncoding(Base32), WithBaseURL("/static/"))
+	d, err = s.HashReader(strings.NewReader("main"))
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, s.DigestPath("main.js", d), s.Path("main.js"))
+	ensure.DeepEqual(t, s.DigestPath("generated.css", d), "/static/generated."+d.Hash+".css")
+}
+
+func TestServeFile(t *testing.T) {
+	cases := []struct {
+		path string
+		code int
+	}{
+		{"/" + hashedMainJS, http.StatusOK},
+		{"/prefix/" + hashedMainJS, http.StatusOK},
+		{"/main.60797db6e8ff.js", http.StatusOK},
+		{"/main.000000000000.js", http.StatusGone},
+		{"/assets/main.js", http.StatusGone},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest("GET", c.path, nil)
+		w := httptest.NewRecorder()
+		ServeFile(w, r, assets, unhashedMainJS)
+		ensure.DeepEqual(t, w.Code, c.code)
+		if c.code == http.StatusOK {
+			ensure.DeepEqual(t, w.Header().Get("Cache-Control"), "public, immutable, max-age=31557600")
+		}
+	}
+
+	s := New(assets, WithDirectoryHash())
+	r := httptest.NewRequest("GET", "/main.js", nil)
+	w := httptest.NewRecorder()
+	s.ServeFile(w, r, unhashedMainJS)
+	ensure.DeepEqual(t, w.Code, http.StatusGone)
+}
+
+func TestPrefix(t *testing.T) {
+	s := New(assets, WithPrefix("/static/"))
+	hashed := s.Path(unhashedMainJS)
+	ensure.DeepEqual(t, hashed, "/static/"+hashedMainJS)
+	ensure.DeepEqual(t, New(assets, WithPrefix("/static/"), WithBaseURL("https://cdn/")).Path(unhashedMainJS), "https://cdn/"+hashedMainJS)
+
+	// the trailing slash is optional
+	for _, prefix := range []string{"/static", "/static/"} {
+		s := New(assets, WithPrefix(prefix))
+		hashed := s.Path(unhashedMainJS)
+		ensure.DeepEqual(t, hashed, "/static/"+hashedMainJS)
+		r := httptest.NewRequest("GET", hashed, nil)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		ensure.DeepEqual(t, w.Code, http.StatusOK, prefix)
+	}
+
+	cases := []struct {
+		path string
+		code int
+	}{
+		{hashed, http.StatusOK},
+		{"/" + hashedMainJS, http.StatusNotFound},
+		{"/staticx/" + hashedMainJS, http.StatusNotFound},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest("GET", c.path, nil)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		ensure.DeepEqual(t, w.Code, c.code)
+	}
+}
+
+func BenchmarkMaybePathCold(b *testing.B) {
+	fsys := fstest.MapFS{"assets/main.js": {Data: bytes.Repeat([]byte("main"), 4096)}}
+	s := New(fsys)
+	b.ReportAllocs()
+	for b.Loop() {
+		s.ResetCache()
+		if _, err := s.MaybePath("assets/main.js"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkServeHTTP(b *testing.B) {
+	s := New(assets)
+	r := httptest.NewRequest("GET", "/"+hashedMainJS, nil)
+	b.ReportAllocs()
+	for b.Loop() {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		if w.Code != http.StatusOK {
+			b.Fatal(w.Code)
+		}
+	}
+}
+
+// BenchmarkServeLarge compares serving a large file from os.DirFS, which uses
+// sendfile, to serving it from a memory mapping or from memory, which copy it.
+func BenchmarkServeLarge(b *testing.B) {
+	dir := b.TempDir()
+	data := bytes.Repeat([]byte("a"), 4<<20)
+	if err := os.WriteFile(filepath.Join(dir, "large.bin"), data, 0o644); err != nil {
+		b.Fatal(err)
+	}
+	cases := []struct {
+		name string
+		fsys fs.FS
+		opts []Option
+	}{
+		{"DirFS", os.DirFS(dir), nil},
+		{"Mmap", os.DirFS(dir), []Option{WithMmap("*.bin")}},
+		{"MapFS", fstest.MapFS{"large.bin": {Data: data}}, nil},
+	}
+	for _, c := range cases {
+		b.Run(c.name, func(b *testing.B) {
+			s := New(c.fsys, c.opts...)
+			server := httptest.NewServer(s)
+			defer server.Close()
+			url := server.URL + "/" + s.Path("large.bin")
+			b.SetBytes(int64(len(data)))
+			b.ReportAllocs()
+			for b.Loop() {
+				res, err := http.Get(url)
+				if err != nil {
+					b.Fatal(err)
+				}
+				_, err = io.Copy(io.Discard, res.Body)
+				res.Body.Close()
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
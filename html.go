@@ -0,0 +1,124 @@
+package hashfs
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/tdewolff/parse/v2"
+	"github.com/tdewolff/parse/v2/html"
+)
+
+// RewriteHTML returns a middleware which rewrites src and href attributes in
+// HTML responses from next that start with prefix to the hashed paths of the
+// referenced files. For example with the prefix "/static/", a reference to
+// "/static/main.js" becomes "/static/main.60797db6e8ff.js", or uses the base
+// URL if one is configured. References to missing files are left unchanged.
+func (s *Server) RewriteHTML(prefix string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hw := &htmlWriter{ResponseWriter: w}
+		next.ServeHTTP(hw, r)
+		if !hw.html {
+			return
+		}
+		out := s.rewriteHTML(prefix, hw.buf.Bytes())
+		w.WriteHeader(hw.code)
+		w.Write(out)
+	})
+}
+
+// htmlWriter buffers HTML responses, and passes through all others.
+type htmlWriter struct {
+	http.ResponseWriter
+	buf         bytes.Buffer
+	code        int
+	wroteHeader bool
+	html        bool
+}
+
+func (w *htmlWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	h := w.Header()
+	mediaType, _, _ := mime.ParseMediaType(h.Get("Content-Type"))
+	if mediaType == "text/html" && h.Get("Content-Encoding") == "" {
+		w.html = true
+		w.code = code
+		h.Del("Content-Length")
+		return
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *htmlWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		if w.Header().Get("Content-Type") == "" {
+			w.Header().Set("Content-Type", http.DetectContentType(p))
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.html {
+		return w.buf.Write(p)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (s *Server) rewriteHTML(prefix string, in []byte) []byte {
+	var out bytes.Buffer
+	l := html.NewLexer(parse.NewInputBytes(in))
+	for {
+		tt, data := l.Next()
+		switch tt {
+		case html.ErrorToken:
+			if !errors.Is(l.Err(), io.EOF) {
+				return in
+			}
+			return out.Bytes()
+		case html.AttributeToken:
+			key := string(l.AttrKey())
+			val := l.AttrVal()
+			if (key == "src" || key == "href") && len(val) > 0 {
+				out.Write(data[:len(data)-len(val)])
+				out.Write(s.rewriteReference(prefix, val))
+				continue
+			}
+		}
+		out.Write(data)
+	}
+}
+
+// rewriteReference rewrites an attribute value, which may be quoted.
+func (s *Server) rewriteReference(prefix string, val []byte) []byte {
+	var quote string
+	if val[0] == '"' || val[0] == '\'' {
+		quote = string(val[0])
+	}
+	ref := strings.TrimSuffix(strings.TrimPrefix(string(val), quote), quote)
+	filename, found := strings.CutPrefix(ref, prefix)
+	if !found {
+		return val
+	}
+	unescaped, err := url.PathUnescape(filename)
+	if err != nil {
+		return val
+	}
+	e, err := s.hash(unescaped)
+	if err != nil {
+		return val
+	}
+	hashed := e.path
+	if unescaped != filename {
+		hashed = escapePath(hashed)
+	}
+	base := s.baseURL
+	if base == "" {
+		base = prefix
+	}
+	return []byte(quote + base + hashed + quote)
+}
@@ -0,0 +1,57 @@
+package hashfs
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/daaku/ensure"
+)
+
+func TestRewriteHTML(t *testing.T) {
+	cases := []struct {
+		contentType, in, out string
+	}{
+		{
+			"text/html; charset=utf-8",
+			`<script src="/static/assets/main.js"></script><link href='/static/assets/main.css'><a href=/static/assets/foo>`,
+			`<script src="/static/assets/main.60797db6e8ff.js"></script><link href='/static/assets/main.3b8e3d604b9f.css'><a href=/static/assets/foo.b5bb9d8014a0>`,
+		},
+		{
+			"",
+			`<!doctype html><img src="/static/missing.png"><a href="/other/assets/foo">`,
+			`<!doctype html><img src="/static/missing.png"><a href="/other/assets/foo">`,
+		},
+		{
+			"text/plain",
+			`<script src="/static/assets/main.js"></script>`,
+			`<script src="/static/assets/main.js"></script>`,
+		},
+	}
+	for _, c := range cases {
+		h := New(assets).RewriteHTML("/static/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if c.contentType != "" {
+				w.Header().Set("Content-Type", c.contentType)
+			}
+			io.WriteString(w, c.in)
+		}))
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+		ensure.DeepEqual(t, w.Code, http.StatusOK)
+		ensure.DeepEqual(t, w.Body.String(), c.out)
+	}
+}
+
+func TestRewriteHTMLBaseURL(t *testing.T) {
+	s := New(assets, WithBaseURL("https://cdn.example.com/"))
+	h := s.RewriteHTML("/static/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.WriteHeader(http.StatusNotFound)
+		io.WriteString(w, `<script src="/static/assets/main.js"></script>`)
+	}))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	ensure.DeepEqual(t, w.Code, http.StatusNotFound)
+	ensure.DeepEqual(t, w.Body.String(), `<script src="https://cdn.example.com/assets/main.60797db6e8ff.js"></script>`)
+}
@@ -0,0 +1,131 @@
+package hashfs
+
+import (
+	"io/fs"
+	"net/http"
+	"strings"
+)
+
+// FromHTTPFileSystem returns a file system reading from the http.FileSystem,
+// for use as the source of a Server.
+func FromHTTPFileSystem(hfs http.FileSystem) fs.FS {
+	return httpFS{hfs: hfs}
+}
+
+type httpFS struct {
+	hfs http.FileSystem
+}
+
+func (h httpFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	f, err := h.hfs.Open("/" + name)
+	if err != nil {
+		return nil, err
+	}
+	return httpFile{f}, nil
+}
+
+// httpFile adds ReadDir to an http.File.
+type httpFile struct {
+	http.File
+}
+
+func (f httpFile) ReadDir(n int) ([]fs.DirEntry, error) {
+	infos, err := f.Readdir(n)
+	entries := make([]fs.DirEntry, len(infos))
+	for i, fi := range infos {
+		entries[i] = fs.FileInfoToDirEntry(fi)
+	}
+	return entries, err
+}
+
+// HTTPFileSystem returns an http.FileSystem which opens files by their hashed
+// paths, for use with libraries which do not accept an fs.FS. CSS and
+// JavaScript files have the same rewritten content as when they are served.
+// Directories can be opened by their paths.
+func (s *Server) HTTPFileSystem() http.FileSystem {
+	return http.FS(hashedFS{s: s})
+}
+
+// Wrap returns a file system which opens files by their hashed paths, as well
+// as their original names, using the default Server for the file system.
+func Wrap(fsys fs.FS) fs.FS {
+	return defaultServer(fsys).FS()
+}
+
+// FS returns a file system which opens files by their hashed paths, as well as
+// their original names, for use with third party file servers, uploaders or
+// testing tools. CSS and JavaScript files have the same rewritten content as
+// when they are served. Directories list the original names and sizes.
+func (s *Server) FS() fs.FS {
+	return hashedFS{s: s, originals: true}
+}
+
+// hashedFS opens files by their hashed paths, and optionally their original
+// names.
+type hashedFS struct {
+	s         *Server
+	originals bool
+}
+
+func (h hashedFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	if h.s.isHidden(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	if fi, err := fs.Stat(h.s.fs, name); err == nil && (fi.IsDir() || h.originals) {
+		return h.s.open(name)
+	}
+	filename, err := h.s.Unhashed(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	return h.s.open(filename)
+}
+
+// open opens the content served for filename, which is the rewritten content
+// for CSS and JavaScript files.
+func (s *Server) open(filename string) (fs.File, error) {
+	f, err := s.fs.Open(filename)
+	if err != nil || !isRewritable(filename) {
+		return f, err
+	}
+	content, ok := s.rewritten(filename)
+	if !ok {
+		return f, nil
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return &memFile{Reader: strings.NewReader(content), fi: fi}, nil
+}
+
+// memFile is a file with content in memory.
+type memFile struct {
+	*strings.Reader
+	fi fs.FileInfo
+}
+
+func (f *memFile) Stat() (fs.FileInfo, error) {
+	return memFileInfo{FileInfo: f.fi, size: f.Size()}, nil
+}
+
+func (f *memFile) Close() error {
+	return nil
+}
+
+// memFileInfo overrides the size of a FileInfo.
+type memFileInfo struct {
+	fs.FileInfo
+	size int64
+}
+
+func (fi memFileInfo) Size() int64 {
+	return fi.size
+}
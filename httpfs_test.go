@@ -0,0 +1,56 @@
+package hashfs
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+
+	"github.com/daaku/ensure"
+)
+
+func TestFromHTTPFileSystem(t *testing.T) {
+	fsys := FromHTTPFileSystem(http.FS(assets))
+	ensure.Nil(t, fstest.TestFS(fsys, unhashedMainJS, "assets/sub/main.css"))
+	ensure.DeepEqual(t, New(fsys).Path(unhashedMainJS), hashedMainJS)
+}
+
+func TestHTTPFileSystem(t *testing.T) {
+	s := New(assets)
+	hfs := s.HTTPFileSystem()
+
+	f, err := hfs.Open("/" + s.Path("assets/main.css"))
+	ensure.Nil(t, err)
+	content, err := io.ReadAll(f)
+	ensure.Nil(t, err)
+	expected, _ := s.rewritten("assets/main.css")
+	ensure.DeepEqual(t, string(content), expected)
+	fi, err := f.Stat()
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, fi.Size(), int64(len(expected)))
+	ensure.Nil(t, f.Close())
+
+	_, err = hfs.Open("/" + unhashedMainJS)
+	ensure.True(t, errors.Is(err, fs.ErrNotExist))
+
+	r := httptest.NewRequest("GET", "/"+hashedMainJS, nil)
+	w := httptest.NewRecorder()
+	http.FileServer(hfs).ServeHTTP(w, r)
+	ensure.DeepEqual(t, w.Code, http.StatusOK)
+}
+
+func TestWrap(t *testing.T) {
+	fsys := Wrap(assets)
+	for _, name := range []string{hashedMainJS, unhashedMainJS} {
+		content, err := fs.ReadFile(fsys, name)
+		ensure.Nil(t, err)
+		ensure.DeepEqual(t, len(content), 21)
+	}
+	_, err := fs.ReadFile(fsys, "assets/main.000000000000.js")
+	ensure.True(t, errors.Is(err, fs.ErrNotExist))
+	_, err = fs.ReadFile(fsys, "../main.js")
+	ensure.True(t, errors.Is(err, fs.ErrInvalid))
+}
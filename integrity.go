@@ -0,0 +1,66 @@
+package hashfs
+
+import (
+	"crypto"
+	_ "crypto/sha512" // register SHA-384 and SHA-512
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+)
+
+// WithIntegrity configures the algorithm used for Subresource Integrity
+// values. It must be one of crypto.SHA256, crypto.SHA384 or crypto.SHA512. The
+// default is crypto.SHA256, which reuses the digest computed for the path.
+func WithIntegrity(h crypto.Hash) Option {
+	return func(s *Server) {
+		s.integrity = h
+	}
+}
+
+// Integrity returns the Subresource Integrity value for filename, such as
+// "sha256-<base64 digest>".
+func Integrity(fs fs.FS, filename string) (string, error) {
+	return defaultServer(fs).Integrity(filename)
+}
+
+// Integrity returns the Subresource Integrity value for filename, such as
+// "sha256-<base64 digest>". The value is cached alongside the hashed path.
+func (s *Server) Integrity(filename string) (string, error) {
+	cached, found := s.integrities.Load(filename)
+	if found {
+		return cached.(string), nil
+	}
+
+	e, err := s.hash(filename)
+	if err != nil {
+		return "", err
+	}
+	// the digest of a negotiated image also covers its variants
+	digest := e.digest
+	if digest == nil || s.customHash || s.integrity != crypto.SHA256 || len(s.imageVariants(filename)) > 0 {
+		r, err := s.content(filename)
+		if err != nil {
+			return "", err
+		}
+		defer r.Close()
+		h := s.integrity.New()
+		if _, err := io.Copy(h, r); err != nil {
+			return "", fmt.Errorf("hashfs: error reading file %q: %w", filename, err)
+		}
+		digest = h.Sum(nil)
+	}
+
+	integrity := s.integrityAlg() + "-" + base64.StdEncoding.EncodeToString(digest)
+	if !s.noCache {
+		s.integrities.Store(filename, integrity)
+	}
+	return integrity, nil
+}
+
+// integrityAlg returns the name of the integrity algorithm used in Subresource
+// Integrity values, such as "sha256".
+func (s *Server) integrityAlg() string {
+	return strings.ToLower(strings.ReplaceAll(s.integrity.String(), "-", ""))
+}
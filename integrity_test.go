@@ -0,0 +1,54 @@
+package hashfs
+
+import (
+	"crypto"
+	"crypto/sha1"
+	"regexp"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/daaku/ensure"
+)
+
+func TestIntegrity(t *testing.T) {
+	fsys := fstest.MapFS{"main.js": {Data: []byte("alert('hello world')\n")}}
+	cases := []struct {
+		opts      []Option
+		integrity string
+	}{
+		{nil, "sha256-YHl9tuj/MtoXfyCKy4Cp/G90fPu+kKER6mpyVrUSBY8="},
+		{[]Option{WithHash(sha1.New)}, "sha256-YHl9tuj/MtoXfyCKy4Cp/G90fPu+kKER6mpyVrUSBY8="},
+		{[]Option{WithIntegrity(crypto.SHA384)}, "sha384-haKnO6XqWPD1NtkSxrR8XNcO/IQD/c4EnWWWA6l+smxzSzP8yvRf9VUQfoOaa1Zp"},
+		{[]Option{WithIntegrity(crypto.SHA512)}, "sha512-eRHbo/X8JC7SX4r8PCLt9ymow4Q0UFpjapJtLjx+7l+ouZkQngSi6nHkyNGZkVN6vrmwhEB5mRS5iJdoHDX72w=="},
+	}
+	for _, c := range cases {
+		integrity, err := New(fsys, c.opts...).Integrity("main.js")
+		ensure.Nil(t, err)
+		ensure.DeepEqual(t, integrity, c.integrity)
+	}
+}
+
+func TestIntegrityManifest(t *testing.T) {
+	fsys := fstest.MapFS{"main.js": {Data: []byte("alert('hello world')\n")}}
+	s := New(fsys)
+	ensure.Nil(t, s.LoadManifest(strings.NewReader(`{"main.js": "main.abcdef.js"}`)))
+	integrity, err := s.Integrity("main.js")
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, integrity, "sha256-YHl9tuj/MtoXfyCKy4Cp/G90fPu+kKER6mpyVrUSBY8=")
+}
+
+func TestIntegrityImageVariants(t *testing.T) {
+	fsys := fstest.MapFS{
+		"photo.png":  {Data: []byte("alert('hello world')\n")},
+		"photo.webp": {Data: []byte("webp")},
+	}
+	integrity, err := New(fsys, WithImageNegotiation("image/webp")).Integrity("photo.png")
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, integrity, "sha256-YHl9tuj/MtoXfyCKy4Cp/G90fPu+kKER6mpyVrUSBY8=")
+}
+
+func TestIntegrityMissing(t *testing.T) {
+	_, err := Integrity(assets, "missing.js")
+	ensure.Err(t, err, regexp.MustCompile("hashfs: error opening file"))
+}
@@ -0,0 +1,96 @@
+package hashfs
+
+import (
+	"fmt"
+	"html"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// DirEntry is an entry of a directory listing.
+type DirEntry struct {
+	fs.DirEntry
+	URL string // the escaped hashed path of a file relative to the directory, or the name of a directory with a trailing slash
+}
+
+// WithDirectoryListing enables listing directories without an index file,
+// which are otherwise not found. The entries link to the hashed paths of files
+// and exclude hidden files. If render is nil a plain HTML listing is served,
+// otherwise render writes the response for the directory.
+func WithDirectoryListing(render func(w http.ResponseWriter, r *http.Request, dir string, entries []DirEntry)) Option {
+	return func(s *Server) {
+		s.listing = true
+		s.renderListing = render
+	}
+}
+
+// isDir reports if name is a directory which is not hidden.
+func (s *Server) isDir(name string) bool {
+	name = strings.Trim(name, "/")
+	if name == "" {
+		name = "."
+	}
+	if s.isHidden(name) {
+		return false
+	}
+	fi, err := fs.Stat(s.fs, name)
+	return err == nil && fi.IsDir()
+}
+
+// serveListing serves the listing of the directory, if enabled.
+func (s *Server) serveListing(w http.ResponseWriter, r *http.Request, dir string) {
+	if !s.listing {
+		s.serveError(w, r, fmt.Errorf("hashfs: directory listing disabled for %q: %w", dir, fs.ErrNotExist))
+		return
+	}
+	dir = strings.Trim(dir, "/")
+	if dir == "" {
+		dir = "."
+	}
+	dirEntries, err := fs.ReadDir(s.fs, dir)
+	if err != nil {
+		s.serveError(w, r, fmt.Errorf("hashfs: error reading directory: %w", err))
+		return
+	}
+	var entries []DirEntry
+	for _, d := range dirEntries {
+		name := path.Join(dir, d.Name())
+		if s.isHidden(name) {
+			continue
+		}
+		if d.IsDir() {
+			entries = append(entries, DirEntry{DirEntry: d, URL: escapePath(d.Name()) + "/"})
+			continue
+		}
+		hashed, err := s.MaybePath(name)
+		if err != nil {
+			continue
+		}
+		if s.baseURL != "" {
+			hashed = s.baseURL + escapePath(strings.TrimPrefix(hashed, s.baseURL))
+		} else {
+			// relative to the listed directory, since the Server may be
+			// behind http.StripPrefix
+			hashed = escapePath(relativePath(dir, strings.TrimPrefix(hashed, s.prefix)))
+		}
+		entries = append(entries, DirEntry{DirEntry: d, URL: hashed})
+	}
+
+	w.Header().Set("Cache-Control", "no-cache")
+	if s.renderListing != nil {
+		s.renderListing(w, r, dir, entries)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprintf(w, "<!doctype html>\n<meta name=\"viewport\" content=\"width=device-width\">\n<pre>\n")
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() {
+			name += "/"
+		}
+		fmt.Fprintf(w, "<a href=\"%s\">%s</a>\n", html.EscapeString(e.URL), html.EscapeString(name))
+	}
+	fmt.Fprintf(w, "</pre>\n")
+}
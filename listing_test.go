@@ -0,0 +1,100 @@
+package hashfs
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/daaku/ensure"
+)
+
+var listingFS = fstest.MapFS{
+	"dir/main.txt":    {Data: []byte("main")},
+	"dir/my file.txt": {Data: []byte("main")},
+	"dir/.hidden":     {Data: []byte("hidden")},
+	"dir/sub/a.txt":   {Data: []byte("a")},
+	"site/index.html": {Data: []byte("index")},
+}
+
+func TestDirectoryListingDisabled(t *testing.T) {
+	for _, opts := range [][]Option{nil, {WithDevMode()}} {
+		s := New(listingFS, opts...)
+		for _, p := range []string{"/", "/dir/", "/dir/sub/"} {
+			r := httptest.NewRequest("GET", p, nil)
+			w := httptest.NewRecorder()
+			s.ServeHTTP(w, r)
+			ensure.DeepEqual(t, w.Code, http.StatusNotFound, p)
+		}
+		r := httptest.NewRequest("GET", "/site/", nil)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		ensure.DeepEqual(t, w.Code, http.StatusOK)
+		ensure.DeepEqual(t, w.Body.String(), "index")
+	}
+}
+
+func TestDirectoryListing(t *testing.T) {
+	s := New(listingFS, WithDirectoryListing(nil), WithHideDotfiles())
+	r := httptest.NewRequest("GET", "/dir/", nil)
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	ensure.DeepEqual(t, w.Code, http.StatusOK)
+	ensure.DeepEqual(t, w.Header().Get("Cache-Control"), "no-cache")
+	ensure.DeepEqual(t, w.Body.String(), `<!doctype html>
+<meta name="viewport" content="width=device-width">
+<pre>
+<a href="main.0d6e4079e367.txt">main.txt</a>
+<a href="my%20file.0d6e4079e367.txt">my file.txt</a>
+<a href="sub/">sub/</a>
+</pre>
+`)
+}
+
+func TestDirectoryListingRender(t *testing.T) {
+	var dirs []string
+	var urls []string
+	render := func(w http.ResponseWriter, r *http.Request, dir string, entries []DirEntry) {
+		dirs = append(dirs, dir)
+		for _, e := range entries {
+			urls = append(urls, e.URL)
+		}
+	}
+	s := New(listingFS, WithDirectoryListing(render), WithPrefix("/static/"))
+	for _, p := range []string{"/static/", "/static/dir/sub/"} {
+		r := httptest.NewRequest("GET", p, nil)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		ensure.DeepEqual(t, w.Code, http.StatusOK)
+	}
+	ensure.DeepEqual(t, dirs, []string{".", "dir/sub"})
+	ensure.DeepEqual(t, urls, []string{"dir/", "site/", "a.ca978112ca1b.txt"})
+
+	// unhashed names are listed in development
+	urls = nil
+	s = New(listingFS, WithDirectoryListing(render), WithDevMode())
+	r := httptest.NewRequest("GET", "/dir/sub/", nil)
+	s.ServeHTTP(httptest.NewRecorder(), r)
+	ensure.DeepEqual(t, urls, []string{"a.txt"})
+}
+
+func TestDirectoryListingStripPrefix(t *testing.T) {
+	h := http.StripPrefix("/static", New(listingFS, WithDirectoryListing(nil)))
+	r := httptest.NewRequest("GET", "/static/dir/", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	ensure.DeepEqual(t, w.Code, http.StatusOK)
+	href := regexp.MustCompile(`href="([^"]*)"`)
+	for _, m := range href.FindAllStringSubmatch(w.Body.String(), -1) {
+		ref, err := url.Parse(m[1])
+		ensure.Nil(t, err)
+		u := r.URL.ResolveReference(ref)
+		ensure.True(t, strings.HasPrefix(u.Path, "/static/dir/"), u)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", u.String(), nil))
+		ensure.DeepEqual(t, w.Code, http.StatusOK, u)
+	}
+}
@@ -0,0 +1,376 @@
+package hashfs
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"iter"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Manifest returns the mapping of every file in the file system to its hashed
+// path.
+func (s *Server) Manifest() (map[string]string, error) {
+	manifest := make(map[string]string)
+	err := fs.WalkDir(s.fs, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || s.isHidden(name) {
+			return err
+		}
+		e, err := s.hash(name)
+		if err != nil {
+			return err
+		}
+		manifest[name] = e.path
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// All returns an iterator over every file in the file system and its hashed
+// path, using the default Server for the file system.
+func All(fsys fs.FS) iter.Seq2[string, string] {
+	return defaultServer(fsys).All()
+}
+
+// All returns an iterator over every file in the file system and its hashed
+// path, in lexical order. Files are hashed as they are reached, so stopping
+// early avoids hashing the rest. Files which cannot be hashed are skipped, use
+// Manifest to handle errors.
+func (s *Server) All() iter.Seq2[string, string] {
+	return func(yield func(string, string) bool) {
+		fs.WalkDir(s.fs, ".", func(name string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() || s.isHidden(name) {
+				return nil
+			}
+			e, err := s.hash(name)
+			if err != nil {
+				return nil
+			}
+			if !yield(name, e.path) {
+				return fs.SkipAll
+			}
+			return nil
+		})
+	}
+}
+
+// WriteManifest writes a JSON object mapping every file in the file system to
+// its hashed path. The keys are sorted, so the output is stable.
+func WriteManifest(fs fs.FS, w io.Writer) error {
+	return defaultServer(fs).WriteManifest(w)
+}
+
+// WriteManifest writes a JSON object mapping every file in the file system to
+// its hashed path. The keys are sorted, so the output is stable.
+func (s *Server) WriteManifest(w io.Writer) error {
+	manifest, err := s.Manifest()
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(manifest)
+}
+
+// ManifestEntry is the hashed path and Subresource Integrity value of a file, as
+// written by WriteIntegrityManifest.
+type ManifestEntry struct {
+	Path      string `json:"path"`
+	Integrity string `json:"integrity"`
+}
+
+// IntegrityManifest returns the mapping of every file in the file system to its
+// hashed path and Subresource Integrity value. Unless WithHash or WithIntegrity
+// change the algorithm, both come from the same digest, so each file is read
+// once.
+func (s *Server) IntegrityManifest() (map[string]ManifestEntry, error) {
+	manifest := make(map[string]ManifestEntry)
+	err := fs.WalkDir(s.fs, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || s.isHidden(name) {
+			return err
+		}
+		e, err := s.hash(name)
+		if err != nil {
+			return err
+		}
+		integrity, err := s.Integrity(name)
+		if err != nil {
+			return err
+		}
+		manifest[name] = ManifestEntry{Path: e.path, Integrity: integrity}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// WriteIntegrityManifest writes a JSON object mapping every file in the file
+// system to its ManifestEntry, using the default Server for the file system.
+func WriteIntegrityManifest(fs fs.FS, w io.Writer) error {
+	return defaultServer(fs).WriteIntegrityManifest(w)
+}
+
+// WriteIntegrityManifest writes a JSON object mapping every file in the file
+// system to its ManifestEntry. The keys are sorted, so the output is stable.
+// It is read by LoadManifest like a manifest written by WriteManifest, and the
+// integrity values are used by Integrity.
+func (s *Server) WriteIntegrityManifest(w io.Writer) error {
+	manifest, err := s.IntegrityManifest()
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(manifest)
+}
+
+// LoadManifest reads a manifest written by WriteManifest and uses it for the
+// hashed paths of the default Server for the file system.
+func LoadManifest(fs fs.FS, r io.Reader) error {
+	return defaultServer(fs).LoadManifest(r)
+}
+
+// LoadManifest reads a manifest written by WriteManifest or
+// WriteIntegrityManifest and uses it for the hashed paths instead of opening
+// and hashing files, which is useful for slow
+// file systems. Files missing from the manifest will not be found. It may be
+// called while serving, but should be called before the Server is used to
+// avoid hashing files in the meantime.
+func (s *Server) LoadManifest(r io.Reader) error {
+	manifest, err := s.readManifest(r)
+	if err != nil {
+		return err
+	}
+	// set first, so hashes computed concurrently are not stored over the
+	// manifest
+	s.manifestOnly.Store(true)
+	alg := s.integrityAlg()
+	for filename, e := range manifest {
+		s.hashes.Store(filename, e.hashEntry)
+		// values for another algorithm are computed again when requested
+		if strings.HasPrefix(e.integrity, alg+"-") && !s.noCache {
+			s.integrities.Store(filename, e.integrity)
+		}
+		if s.contentAddressed {
+			s.addressed.Store(e.path, filename)
+		}
+	}
+	return nil
+}
+
+// ErrManifestSignature is returned by LoadSignedManifest when the signature
+// does not match the manifest.
+var ErrManifestSignature = errors.New("hashfs: invalid manifest signature")
+
+// SignManifest returns the ed25519 signature of a manifest written by
+// WriteManifest, for verification by LoadSignedManifest. The manifest must be
+// stored exactly as signed.
+func SignManifest(key ed25519.PrivateKey, manifest []byte) []byte {
+	return ed25519.Sign(key, manifest)
+}
+
+// LoadSignedManifest verifies and loads a manifest signed by SignManifest for
+// the default Server for the file system.
+func LoadSignedManifest(fs fs.FS, r io.Reader, sig []byte, key ed25519.PublicKey) error {
+	return defaultServer(fs).LoadSignedManifest(r, sig, key)
+}
+
+// LoadSignedManifest is LoadManifest for a manifest signed by SignManifest,
+// which proves the served mapping is exactly what the build produced. If the
+// signature does not match, ErrManifestSignature is returned and the Server
+// refuses to serve any files.
+func (s *Server) LoadSignedManifest(r io.Reader, sig []byte, key ed25519.PublicKey) error {
+	manifest, err := io.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("hashfs: error reading manifest: %w", err)
+	}
+	if len(key) != ed25519.PublicKeySize || !ed25519.Verify(key, manifest, sig) {
+		s.manifestOnly.Store(true)
+		s.hashes.Clear()
+		return ErrManifestSignature
+	}
+	return s.LoadManifest(bytes.NewReader(manifest))
+}
+
+// LoadPreviousManifest reads a manifest from a previous release. It can be
+// called multiple times to recognize the hashed paths of the last few
+// releases, which clients may still request during a rolling deploy. A
+// previous hashed path is served immutably if the file system contains it,
+// such as when the output of CopyAll is kept across releases, and otherwise
+// the current content of the file is served with Cache-Control: no-cache. It
+// should be called before the Server is used.
+func (s *Server) LoadPreviousManifest(r io.Reader) error {
+	manifest, err := s.readManifest(r)
+	if err != nil {
+		return err
+	}
+	for filename, e := range manifest {
+		s.previous.Store(e.path, filename)
+	}
+	return nil
+}
+
+// servePrevious serves urlpath if it is a hashed path from a previous
+// manifest, returning false otherwise.
+func (s *Server) servePrevious(w http.ResponseWriter, r *http.Request, urlpath string) bool {
+	filename, ok := s.previous.Load(urlpath)
+	if !ok {
+		return false
+	}
+	if s.exists(urlpath) {
+		s.serveFile(w, r, urlpath, s.immutable(r.Context(), filename.(string)))
+		return true
+	}
+	if !s.exists(filename.(string)) {
+		return false
+	}
+	s.serveFile(w, r, filename.(string), "no-cache")
+	return true
+}
+
+// manifestEntry is a hashEntry read from a manifest, along with its integrity
+// value if the manifest has one.
+type manifestEntry struct {
+	*hashEntry
+	integrity string
+}
+
+func (s *Server) readManifest(r io.Reader) (map[string]manifestEntry, error) {
+	var manifest map[string]json.RawMessage
+	if err := json.NewDecoder(r).Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("hashfs: invalid manifest: %w", err)
+	}
+	entries := make(map[string]manifestEntry, len(manifest))
+	for filename, raw := range manifest {
+		// entries are either the hashed path or a ManifestEntry
+		var entry ManifestEntry
+		if err := json.Unmarshal(raw, &entry.Path); err != nil {
+			if err := json.Unmarshal(raw, &entry); err != nil {
+				return nil, fmt.Errorf("hashfs: invalid manifest entry for %q: %w", filename, err)
+			}
+		}
+		hashed := entry.Path
+		if hashed == filename && matchAny(s.exclude, filename) {
+			entries[filename] = manifestEntry{&hashEntry{path: hashed, manifest: true}, entry.Integrity}
+			continue
+		}
+		var parsed, hash string
+		switch {
+		case s.contentAddressed:
+			if h, found := strings.CutPrefix(hashed, addressedPrefix); found {
+				parsed, hash = filename, h
+			}
+		case s.queryVersion:
+			parsed, hash, _ = strings.Cut(hashed, "?v=")
+		case s.namer != nil:
+			parsed, hash = s.namer.Parse(hashed)
+		default:
+			ext := s.ext(filename)
+			base := filename[:len(filename)-len(ext)] + "."
+			if len(hashed) > len(base)+len(ext) && strings.HasPrefix(hashed, base) && strings.HasSuffix(hashed, ext) {
+				parsed, hash = filename, hashed[len(base):len(hashed)-len(ext)]
+			}
+		}
+		if parsed != filename || hash == "" {
+			return nil, fmt.Errorf("hashfs: invalid manifest entry for %q: %q", filename, hashed)
+		}
+		entries[filename] = manifestEntry{&hashEntry{path: hashed, hash: hash, manifest: true}, entry.Integrity}
+	}
+	return entries, nil
+}
+
+// CopyAll writes every file in the file system to the dst directory using its
+// hashed path, preserving the directory structure. It returns the manifest of
+// the copied files.
+func CopyAll(dst string, fs fs.FS) (map[string]string, error) {
+	return defaultServer(fs).CopyAll(dst)
+}
+
+// CopyAll writes every file in the file system to the dst directory using its
+// hashed path, preserving the directory structure. It returns the manifest of
+// the copied files.
+func (s *Server) CopyAll(dst string) (map[string]string, error) {
+	manifest, err := s.Manifest()
+	if err != nil {
+		return nil, err
+	}
+	for filename, hashed := range manifest {
+		// the query of a query versioned path is not part of the file name
+		name, _, _ := strings.Cut(hashed, "?")
+		if err := s.copyFile(filepath.Join(dst, filepath.FromSlash(name)), filename); err != nil {
+			return nil, err
+		}
+	}
+	return manifest, nil
+}
+
+func (s *Server) copyFile(dst, filename string) error {
+	r, err := s.content(filename)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return fmt.Errorf("hashfs: error creating directory: %w", err)
+	}
+	f, err := os.Create(dst)
+	if err != nil {
+		return fmt.Errorf("hashfs: error creating file: %w", err)
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return fmt.Errorf("hashfs: error writing file %q: %w", dst, err)
+	}
+	return f.Close()
+}
+
+// WriteFileFS is a destination for CopyAllTo, such as an in-memory file system
+// or a client for static hosting.
+type WriteFileFS interface {
+	// WriteFile writes data to the named file, creating any necessary
+	// directories. The name uses forward slashes.
+	WriteFile(name string, data []byte, perm fs.FileMode) error
+}
+
+// CopyAllTo writes every file in the file system to dst using its hashed path.
+// It returns the manifest of the copied files.
+func CopyAllTo(dst WriteFileFS, fs fs.FS) (map[string]string, error) {
+	return defaultServer(fs).CopyAllTo(dst)
+}
+
+// CopyAllTo writes every file in the file system to dst using its hashed path.
+// It returns the manifest of the copied files.
+func (s *Server) CopyAllTo(dst WriteFileFS) (map[string]string, error) {
+	manifest, err := s.Manifest()
+	if err != nil {
+		return nil, err
+	}
+	for filename, hashed := range manifest {
+		r, err := s.content(filename)
+		if err != nil {
+			return nil, err
+		}
+		data, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			return nil, fmt.Errorf("hashfs: error reading file %q: %w", filename, err)
+		}
+		name, _, _ := strings.Cut(hashed, "?")
+		if err := dst.WriteFile(name, data, 0o644); err != nil {
+			return nil, fmt.Errorf("hashfs: error writing file %q: %w", name, err)
+		}
+	}
+	return manifest, nil
+}
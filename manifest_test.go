@@ -0,0 +1,254 @@
+package hashfs
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"errors"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/daaku/ensure"
+)
+
+func TestWriteManifest(t *testing.T) {
+	var buf bytes.Buffer
+	ensure.Nil(t, WriteManifest(assets, &buf))
+	ensure.DeepEqual(t, buf.String(), `{
+  "assets/bar.txt": "assets/bar.7d865e959b24.txt",
+  "assets/boom.css": "assets/boom.8d7a531d714c.css",
+  "assets/empty": "assets/empty.e3b0c44298fc",
+  "assets/fonts/baz.txt": "assets/fonts/baz.bf07a7fbb825.txt",
+  "assets/foo": "assets/foo.b5bb9d8014a0",
+  "assets/main.css": "assets/main.3b8e3d604b9f.css",
+  "assets/main.js": "assets/main.60797db6e8ff.js",
+  "assets/sub/main.css": "assets/sub/main.de67881c8124.css"
+}
+`)
+}
+
+func TestWriteIntegrityManifest(t *testing.T) {
+	fsys := &countingFS{FS: fstest.MapFS{
+		"main.js":  {Data: []byte("alert('hello world')\n")},
+		"main.txt": {Data: []byte("main")},
+	}}
+	var buf bytes.Buffer
+	ensure.Nil(t, New(fsys).WriteIntegrityManifest(&buf))
+	ensure.DeepEqual(t, buf.String(), `{
+  "main.js": {
+    "path": "main.60797db6e8ff.js",
+    "integrity": "sha256-YHl9tuj/MtoXfyCKy4Cp/G90fPu+kKER6mpyVrUSBY8="
+  },
+  "main.txt": {
+    "path": "main.0d6e4079e367.txt",
+    "integrity": "sha256-DW5AeeNnA+vTfAByL1iR0osOKBHcEUsSkhUSOtzONgU="
+  }
+}
+`)
+	// the integrity values do not read the files again
+	opens := fsys.opens.Swap(0)
+	_, err := New(fsys).Manifest()
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, fsys.opens.Load(), opens)
+
+	s := New(fsys)
+	ensure.Nil(t, s.LoadManifest(strings.NewReader(`{
+  "main.js": {"path": "main.abcdef.js", "integrity": "sha256-loaded"},
+  "main.txt": "main.abcdef.txt"
+}`)))
+	ensure.DeepEqual(t, s.Path("main.js"), "main.abcdef.js")
+	ensure.DeepEqual(t, s.Path("main.txt"), "main.abcdef.txt")
+	integrity, err := s.Integrity("main.js")
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, integrity, "sha256-loaded")
+
+	// values for another algorithm are not used
+	s = New(fsys, WithIntegrity(crypto.SHA384))
+	ensure.Nil(t, s.LoadManifest(strings.NewReader(`{"main.js": {"path": "main.abcdef.js", "integrity": "sha256-loaded"}}`)))
+	integrity, err = s.Integrity("main.js")
+	ensure.Nil(t, err)
+	ensure.StringContains(t, integrity, "sha384-")
+}
+
+func TestLoadManifest(t *testing.T) {
+	fsys := fstest.MapFS{
+		"main.js": {Data: []byte("main")},
+		"new.js":  {Data: []byte("new")},
+	}
+	s := New(fsys)
+	ensure.Nil(t, s.LoadManifest(strings.NewReader(`{"main.js": "main.abcdef.js"}`)))
+	ensure.DeepEqual(t, s.Path("main.js"), "main.abcdef.js")
+
+	r := httptest.NewRequest("GET", "/main.abcdef.js", nil)
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	ensure.DeepEqual(t, w.Code, http.StatusOK)
+	ensure.DeepEqual(t, w.Body.String(), "main")
+	ensure.DeepEqual(t, w.Header().Get("ETag"), `"abcdef"`)
+
+	_, err := s.MaybePath("new.js")
+	ensure.Err(t, err, regexp.MustCompile(`hashfs: file not in manifest "new.js"`))
+	ensure.True(t, errors.Is(err, fs.ErrNotExist))
+}
+
+func TestLoadManifestWhileServing(t *testing.T) {
+	fsys := fstest.MapFS{"main.js": {Data: []byte("main")}}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for range 100 {
+			MaybePath(fsys, "main.js")
+		}
+	}()
+	ensure.Nil(t, LoadManifest(fsys, strings.NewReader(`{"main.js": "main.abcdef.js"}`)))
+	<-done
+	ensure.DeepEqual(t, Path(fsys, "main.js"), "main.abcdef.js")
+}
+
+func TestLoadPreviousManifest(t *testing.T) {
+	fsys := fstest.MapFS{
+		"main.js":        {Data: []byte("main")},
+		"main.abcdef.js": {Data: []byte("old main")},
+		"app.js":         {Data: []byte("app")},
+	}
+	s := New(fsys)
+	ensure.Nil(t, s.LoadPreviousManifest(strings.NewReader(`{"main.js": "main.abcdef.js", "app.js": "app.123456.js"}`)))
+	ensure.Nil(t, s.LoadPreviousManifest(strings.NewReader(`{"gone.js": "gone.123456.js"}`)))
+	cases := []struct {
+		path, body, cacheControl string
+		code                     int
+	}{
+		{"/main.abcdef.js", "old main", "public, immutable, max-age=31557600", http.StatusOK},
+		{"/app.123456.js", "app", "no-cache", http.StatusOK},
+		{"/gone.123456.js", "", "", http.StatusNotFound},
+		{"/app.654321.js", "", "", http.StatusGone},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest("GET", c.path, nil)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		ensure.DeepEqual(t, w.Code, c.code)
+		ensure.DeepEqual(t, w.Header().Get("Cache-Control"), c.cacheControl)
+		if c.code == http.StatusOK {
+			ensure.DeepEqual(t, w.Body.String(), c.body)
+		}
+	}
+}
+
+func TestLoadManifestInvalid(t *testing.T) {
+	cases := []struct {
+		manifest, err string
+	}{
+		{`[]`, "hashfs: invalid manifest"},
+		{`{"main.js": "main.js"}`, `hashfs: invalid manifest entry for "main.js"`},
+		{`{"main.js": "other.abcdef.js"}`, `hashfs: invalid manifest entry for "main.js"`},
+	}
+	for _, c := range cases {
+		err := New(fstest.MapFS{}).LoadManifest(strings.NewReader(c.manifest))
+		ensure.Err(t, err, regexp.MustCompile(regexp.QuoteMeta(c.err)))
+	}
+}
+
+func TestLoadSignedManifest(t *testing.T) {
+	fsys := fstest.MapFS{"main.js": {Data: []byte("main")}}
+	pub, key, err := ed25519.GenerateKey(nil)
+	ensure.Nil(t, err)
+	otherPub, _, err := ed25519.GenerateKey(nil)
+	ensure.Nil(t, err)
+	var manifest bytes.Buffer
+	ensure.Nil(t, New(fsys).WriteManifest(&manifest))
+	sig := SignManifest(key, manifest.Bytes())
+
+	s := New(fsys)
+	ensure.Nil(t, s.LoadSignedManifest(bytes.NewReader(manifest.Bytes()), sig, pub))
+	ensure.DeepEqual(t, s.Path("main.js"), "main.0d6e4079e367.js")
+
+	tampered := bytes.Replace(manifest.Bytes(), []byte("0d6e4079e367"), []byte("000000000000"), 1)
+	cases := []struct {
+		manifest []byte
+		key      ed25519.PublicKey
+	}{
+		{tampered, pub},
+		{manifest.Bytes(), otherPub},
+		{manifest.Bytes(), nil},
+	}
+	for _, c := range cases {
+		s := New(fsys)
+		s.Path("main.js")
+		err := s.LoadSignedManifest(bytes.NewReader(c.manifest), sig, c.key)
+		ensure.True(t, errors.Is(err, ErrManifestSignature))
+
+		// nothing is served after verification fails
+		r := httptest.NewRequest("GET", "/main.0d6e4079e367.js", nil)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		ensure.DeepEqual(t, w.Code, http.StatusNotFound)
+	}
+}
+
+func TestCopyAll(t *testing.T) {
+	dst := t.TempDir()
+	manifest, err := CopyAll(dst, assets)
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, len(manifest), 8)
+	for filename, hashed := range manifest {
+		expected, err := New(assets).content(filename)
+		ensure.Nil(t, err)
+		var buf bytes.Buffer
+		_, err = buf.ReadFrom(expected)
+		ensure.Nil(t, err)
+		actual, err := os.ReadFile(filepath.Join(dst, hashed))
+		ensure.Nil(t, err)
+		ensure.DeepEqual(t, actual, buf.Bytes())
+	}
+}
+
+type mapWriter fstest.MapFS
+
+func (m mapWriter) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	m[name] = &fstest.MapFile{Data: data, Mode: perm}
+	return nil
+}
+
+func TestCopyAllTo(t *testing.T) {
+	dst := mapWriter{}
+	manifest, err := CopyAllTo(dst, assets)
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, len(dst), 8)
+	for filename, hashed := range manifest {
+		expected, err := New(assets).content(filename)
+		ensure.Nil(t, err)
+		var buf bytes.Buffer
+		_, err = buf.ReadFrom(expected)
+		ensure.Nil(t, err)
+		ensure.DeepEqual(t, dst[hashed].Data, buf.Bytes())
+	}
+}
+
+func TestAll(t *testing.T) {
+	manifest, err := New(assets).Manifest()
+	ensure.Nil(t, err)
+	all := make(map[string]string)
+	for filename, hashed := range All(assets) {
+		all[filename] = hashed
+	}
+	ensure.DeepEqual(t, all, manifest)
+
+	fsys := &countingFS{FS: fstest.MapFS{
+		"a.txt": {Data: []byte("a")},
+		"b.txt": {Data: []byte("b")},
+	}}
+	for filename := range New(fsys).All() {
+		ensure.DeepEqual(t, filename, "a.txt")
+		break
+	}
+	// the root is opened twice by fs.WalkDir, and b.txt is never opened
+	ensure.DeepEqual(t, fsys.opens.Load(), int64(3))
+}
@@ -0,0 +1,92 @@
+// Package metrics provides Prometheus metrics for a hashfs.Server.
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/daaku/hashfs"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Metrics collects request, cache and hashing metrics for a hashfs.Server.
+type Metrics struct {
+	requests *prometheus.CounterVec
+	bytes    prometheus.Counter
+	cache    *prometheus.CounterVec
+	hashing  prometheus.Histogram
+}
+
+// New returns Metrics with names prefixed by namespace, which may be empty.
+func New(namespace string) *Metrics {
+	return &Metrics{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "hashfs",
+			Name:      "requests_total",
+			Help:      "Requests served, by status code.",
+		}, []string{"code"}),
+		bytes: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "hashfs",
+			Name:      "response_bytes_total",
+			Help:      "Bytes written in response bodies.",
+		}),
+		cache: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "hashfs",
+			Name:      "cache_lookups_total",
+			Help:      "Cache lookups, by cache and result.",
+		}, []string{"cache", "result"}),
+		hashing: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "hashfs",
+			Name:      "hash_duration_seconds",
+			Help:      "Time taken to read and hash files.",
+			Buckets:   prometheus.ExponentialBuckets(0.0001, 4, 10),
+		}),
+	}
+}
+
+// Options returns the hashfs options which record the metrics.
+func (m *Metrics) Options() []hashfs.Option {
+	return []hashfs.Option{
+		hashfs.WithOnServe(func(r *http.Request, e hashfs.ServeEvent) {
+			m.requests.WithLabelValues(strconv.Itoa(e.Status)).Inc()
+			m.bytes.Add(float64(e.Bytes))
+		}),
+		hashfs.WithCacheObserver(func(cache string, hit bool) {
+			result := "miss"
+			if hit {
+				result = "hit"
+			}
+			m.cache.WithLabelValues(cache, result).Inc()
+		}),
+		hashfs.WithHashObserver(func(ctx context.Context, filename string, start time.Time, d time.Duration) {
+			m.hashing.Observe(d.Seconds())
+		}),
+	}
+}
+
+// Collector returns the collector for registering the metrics.
+func (m *Metrics) Collector() prometheus.Collector {
+	return m
+}
+
+// Describe implements prometheus.Collector.
+func (m *Metrics) Describe(ch chan<- *prometheus.Desc) {
+	m.requests.Describe(ch)
+	m.bytes.Describe(ch)
+	m.cache.Describe(ch)
+	m.hashing.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (m *Metrics) Collect(ch chan<- prometheus.Metric) {
+	m.requests.Collect(ch)
+	m.bytes.Collect(ch)
+	m.cache.Collect(ch)
+	m.hashing.Collect(ch)
+}
@@ -0,0 +1,31 @@
+package metrics
+
+import (
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+
+	"github.com/daaku/ensure"
+	"github.com/daaku/hashfs"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMetrics(t *testing.T) {
+	m := New("test")
+	reg := prometheus.NewRegistry()
+	ensure.Nil(t, reg.Register(m.Collector()))
+
+	s := hashfs.New(fstest.MapFS{"main.js": {Data: []byte("main")}}, m.Options()...)
+	for _, p := range []string{"/" + s.Path("main.js"), "/missing.000000000000.js"} {
+		s.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", p, nil))
+	}
+
+	ensure.DeepEqual(t, testutil.ToFloat64(m.requests.WithLabelValues("200")), 1.0)
+	ensure.DeepEqual(t, testutil.ToFloat64(m.requests.WithLabelValues("404")), 1.0)
+	ensure.DeepEqual(t, testutil.ToFloat64(m.cache.WithLabelValues("hash", "hit")) > 0, true)
+	ensure.DeepEqual(t, testutil.CollectAndCount(m.hashing), 1)
+	count, err := testutil.GatherAndCount(reg)
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, count, 6)
+}
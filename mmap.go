@@ -0,0 +1,41 @@
+package hashfs
+
+import (
+	"bytes"
+	"io/fs"
+	"net/http"
+	"os"
+	"time"
+)
+
+// WithMmap serves files matching the patterns from a read-only memory mapping,
+// for very large files such as WASM bundles or map tiles, instead of reading
+// them into buffers or the content cache. Patterns use the same syntax as
+// WithPassthrough. Only files opened as *os.File, such as with os.DirFS, can
+// be mapped. Other files, and platforms without mmap, are served as usual.
+// Responses are copied from the mapping rather than sent with sendfile, which
+// BenchmarkServeLarge compares.
+func WithMmap(patterns ...string) Option {
+	return func(s *Server) {
+		s.mmap = append(s.mmap, patterns...)
+	}
+}
+
+// serveMmap serves the file from a memory mapping, and returns false if it
+// could not be mapped.
+func (s *Server) serveMmap(w http.ResponseWriter, r *http.Request, served string, modTime time.Time, f fs.File, fi fs.FileInfo) bool {
+	if !matchAny(s.mmap, served) || fi.Size() == 0 {
+		return false
+	}
+	osf, ok := f.(*os.File)
+	if !ok {
+		return false
+	}
+	data, unmap, err := mmapFile(osf, fi.Size())
+	if err != nil {
+		return false
+	}
+	defer unmap()
+	http.ServeContent(w, r, served, modTime, bytes.NewReader(data))
+	return true
+}
@@ -0,0 +1,13 @@
+//go:build !unix
+
+package hashfs
+
+import (
+	"errors"
+	"os"
+)
+
+// mmapFile is not supported on this platform, and files are served as usual.
+func mmapFile(f *os.File, size int64) ([]byte, func() error, error) {
+	return nil, nil, errors.ErrUnsupported
+}
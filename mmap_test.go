@@ -0,0 +1,49 @@
+package hashfs
+
+import (
+	"bytes"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/daaku/ensure"
+)
+
+func TestMmap(t *testing.T) {
+	dir := t.TempDir()
+	data := bytes.Repeat([]byte("0123456789"), 1000)
+	ensure.Nil(t, os.WriteFile(filepath.Join(dir, "app.wasm"), data, 0o644))
+	ensure.Nil(t, os.WriteFile(filepath.Join(dir, "empty.wasm"), nil, 0o644))
+	mapfs := fstest.MapFS{"app.wasm": {Data: data}}
+
+	for _, fsys := range []fs.FS{os.DirFS(dir), mapfs} {
+		s := New(fsys, WithMmap("*.wasm"), WithContentCache(1<<20))
+		r := httptest.NewRequest("GET", "/"+s.Path("app.wasm"), nil)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		ensure.DeepEqual(t, w.Code, http.StatusOK)
+		ensure.DeepEqual(t, w.Body.Bytes(), data)
+		ensure.DeepEqual(t, w.Header().Get("Content-Type"), "application/wasm")
+
+		r = httptest.NewRequest("GET", "/"+s.Path("app.wasm"), nil)
+		r.Header.Set("Range", "bytes=10-14")
+		w = httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		ensure.DeepEqual(t, w.Code, http.StatusPartialContent)
+		ensure.DeepEqual(t, w.Body.String(), "01234")
+
+		// mapped files bypass the content cache
+		ensure.DeepEqual(t, s.Stats().ContentMisses, int64(0))
+	}
+
+	s := New(os.DirFS(dir), WithMmap("*.wasm"))
+	r := httptest.NewRequest("GET", "/"+s.Path("empty.wasm"), nil)
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	ensure.DeepEqual(t, w.Code, http.StatusOK)
+	ensure.DeepEqual(t, w.Body.Len(), 0)
+}
@@ -0,0 +1,17 @@
+//go:build unix
+
+package hashfs
+
+import (
+	"os"
+	"syscall"
+)
+
+// mmapFile maps size bytes of the file read-only.
+func mmapFile(f *os.File, size int64) ([]byte, func() error, error) {
+	data, err := syscall.Mmap(int(f.Fd()), 0, int(size), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, func() error { return syscall.Munmap(data) }, nil
+}
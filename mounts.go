@@ -0,0 +1,96 @@
+package hashfs
+
+import (
+	"cmp"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Mounts serves several file systems under URL prefixes from a single
+// handler, each with its own Server and options. Requests are routed to the
+// mount with the longest matching prefix.
+type Mounts struct {
+	mu     sync.RWMutex
+	mounts []mount
+}
+
+type mount struct {
+	prefix string
+	s      *Server
+}
+
+// NewMounts returns an empty Mounts.
+func NewMounts() *Mounts {
+	return &Mounts{}
+}
+
+// Mount adds or replaces the file system served under the prefix, such as
+// "/static/", and returns its Server. The Server is configured with the prefix
+// using WithPrefix, which replaces a prefix given in the options.
+func (m *Mounts) Mount(prefix string, fs fs.FS, opts ...Option) *Server {
+	s := New(fs, append(slices.Clip(opts), WithPrefix(prefix))...)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.mounts = slices.DeleteFunc(m.mounts, func(mt mount) bool {
+		return mt.prefix == prefix
+	})
+	m.mounts = append(m.mounts, mount{prefix: prefix, s: s})
+	slices.SortFunc(m.mounts, func(a, b mount) int {
+		return cmp.Compare(len(b.prefix), len(a.prefix))
+	})
+	return s
+}
+
+// Unmount removes the file system served under the prefix.
+func (m *Mounts) Unmount(prefix string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.mounts = slices.DeleteFunc(m.mounts, func(mt mount) bool {
+		return mt.prefix == prefix
+	})
+}
+
+func (m *Mounts) lookup(urlpath string) (mount, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, mt := range m.mounts {
+		if strings.HasPrefix(urlpath, mt.prefix) {
+			return mt, true
+		}
+	}
+	return mount{}, false
+}
+
+// Path returns the hashed path of filename in the file system mounted under
+// the prefix, including the prefix, or the base URL of the mount if one is
+// configured.
+func (m *Mounts) Path(prefix, filename string) (string, error) {
+	m.mu.RLock()
+	i := slices.IndexFunc(m.mounts, func(mt mount) bool {
+		return mt.prefix == prefix
+	})
+	var s *Server
+	if i >= 0 {
+		s = m.mounts[i].s
+	}
+	m.mu.RUnlock()
+	if s == nil {
+		return "", fmt.Errorf("hashfs: unknown mount %q", prefix)
+	}
+	return s.MaybePath(filename)
+}
+
+// ServeHTTP serves the request using the mount with the longest matching
+// prefix.
+func (m *Mounts) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	mt, found := m.lookup(r.URL.Path)
+	if !found {
+		http.NotFound(w, r)
+		return
+	}
+	mt.s.ServeHTTP(w, r)
+}
@@ -0,0 +1,64 @@
+package hashfs
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/daaku/ensure"
+)
+
+func TestMounts(t *testing.T) {
+	m := NewMounts()
+	m.Mount("/static/", assets)
+	m.Mount("/static/uploads/", fstest.MapFS{"a.txt": {Data: []byte("upload")}}, WithMaxAge(time.Hour))
+	m.Mount("/gone/", assets)
+	m.Unmount("/gone/")
+
+	uploadPath, err := m.Path("/static/uploads/", "a.txt")
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, uploadPath, "/static/uploads/a.ff4085ad1573.txt")
+	_, err = m.Path("/gone/", "a.txt")
+	ensure.Err(t, err, regexp.MustCompile(`unknown mount "/gone/"`))
+
+	cases := []struct {
+		path, cacheControl string
+		code               int
+	}{
+		{"/static/" + hashedMainJS, "public, immutable, max-age=31557600", http.StatusOK},
+		{uploadPath, "public, immutable, max-age=3600", http.StatusOK},
+		{"/gone/" + hashedMainJS, "", http.StatusNotFound},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest("GET", c.path, nil)
+		w := httptest.NewRecorder()
+		m.ServeHTTP(w, r)
+		ensure.DeepEqual(t, w.Code, c.code)
+		ensure.DeepEqual(t, w.Header().Get("Cache-Control"), c.cacheControl)
+	}
+}
+
+func TestMountsPrefixAndBaseURL(t *testing.T) {
+	m := NewMounts()
+	m.Mount("/s/", assets, WithPrefix("/other/"))
+	m.Mount("/cdn/", assets, WithBaseURL("https://cdn/"))
+
+	hashed, err := m.Path("/s/", unhashedMainJS)
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, hashed, "/s/"+hashedMainJS)
+	r := httptest.NewRequest("GET", hashed, nil)
+	w := httptest.NewRecorder()
+	m.ServeHTTP(w, r)
+	ensure.DeepEqual(t, w.Code, http.StatusOK)
+
+	hashed, err = m.Path("/cdn/", unhashedMainJS)
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, hashed, "https://cdn/"+hashedMainJS)
+	r = httptest.NewRequest("GET", "/cdn/"+hashedMainJS, nil)
+	w = httptest.NewRecorder()
+	m.ServeHTTP(w, r)
+	ensure.DeepEqual(t, w.Code, http.StatusOK)
+}
@@ -0,0 +1,53 @@
+package hashfs
+
+import (
+	"path"
+	"strings"
+)
+
+// Namer controls how the hash is embedded in hashed paths. The default places
+// the hash before the extension, for example main.<hash>.js.
+type Namer interface {
+	// Name returns the hashed path for the filename split into the name
+	// without the extension, the encoded hash and the extension, which may be
+	// empty.
+	Name(name, hash, ext string) string
+
+	// Parse returns the filename and the encoded hash from a hashed path. It
+	// returns empty strings if the path is not a hashed path.
+	Parse(hashed string) (filename, hash string)
+}
+
+// WithNamer configures a Namer for the hashed paths, allowing them to match an
+// existing URL scheme.
+func WithNamer(n Namer) Option {
+	return func(s *Server) {
+		s.namer = n
+	}
+}
+
+// WithDirectoryHash embeds the hash as a directory instead of in the file name,
+// for example assets/<hash>/main.js. This keeps file names unchanged and allows
+// purging a CDN by prefix.
+func WithDirectoryHash() Option {
+	return WithNamer(directoryNamer{})
+}
+
+type directoryNamer struct{}
+
+func (directoryNamer) Name(name, hash, ext string) string {
+	dir, base := path.Split(name)
+	return dir + hash + "/" + base + ext
+}
+
+func (directoryNamer) Parse(hashed string) (string, string) {
+	dir, base := path.Split(hashed)
+	if dir == "" || base == "" {
+		return "", ""
+	}
+	parent, hash := path.Split(strings.TrimSuffix(dir, "/"))
+	if hash == "" {
+		return "", ""
+	}
+	return parent + base, hash
+}
@@ -0,0 +1,122 @@
+package hashfs
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/daaku/ensure"
+)
+
+// dashNamer embeds the hash as main-<hash>.js.
+type dashNamer struct{}
+
+func (dashNamer) Name(name, hash, ext string) string {
+	return name + "-" + hash + ext
+}
+
+func (dashNamer) Parse(hashed string) (string, string) {
+	ext := path.Ext(hashed)
+	name, hash, found := strings.Cut(strings.TrimSuffix(hashed, ext), "-")
+	if !found {
+		return "", ""
+	}
+	return name + ext, hash
+}
+
+func TestNamer(t *testing.T) {
+	fsys := fstest.MapFS{"main.js": {Data: []byte("main")}}
+	s := New(fsys, WithNamer(dashNamer{}))
+	ensure.DeepEqual(t, s.Path("main.js"), "main-0d6e4079e367.js")
+
+	filename, err := s.Unhashed("main-0d6e4079e367.js")
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, filename, "main.js")
+	_, err = s.Unhashed("main.0d6e4079e367.js")
+	ensure.NotNil(t, err)
+
+	m := New(fsys, WithNamer(dashNamer{}))
+	ensure.Nil(t, m.LoadManifest(strings.NewReader(`{"main.js": "main-abcdef.js"}`)))
+	ensure.DeepEqual(t, m.Path("main.js"), "main-abcdef.js")
+	ensure.NotNil(t, m.LoadManifest(strings.NewReader(`{"main.js": "main.abcdef.js"}`)))
+}
+
+func TestDirectoryHash(t *testing.T) {
+	s := New(assets, WithDirectoryHash())
+	hashed := s.Path("assets/main.js")
+	ensure.DeepEqual(t, hashed, "assets/60797db6e8ff/main.js")
+	filename, err := s.Unhashed(hashed)
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, filename, "assets/main.js")
+
+	cases := []struct {
+		path string
+		code int
+	}{
+		{"/" + hashed, http.StatusOK},
+		{"/" + s.Path("assets/empty"), http.StatusOK},
+		{"/assets/000000000000/main.js", http.StatusGone},
+		{"/assets/main.js", http.StatusNotFound},
+		{"/main.js", http.StatusNotFound},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest("GET", c.path, nil)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		ensure.DeepEqual(t, w.Code, c.code)
+	}
+}
+
+var referencesFS = fstest.MapFS{
+	"css/main.css":     {Data: []byte(`a { background: url(../img/logo.png) } b { background: url("icons/a.png?x=1#y") }`)},
+	"css/icons/a.png":  {Data: []byte("a")},
+	"img/logo.png":     {Data: []byte("logo")},
+	"js/main.js":       {Data: []byte("main\n//# sourceMappingURL=main.js.map\n")},
+	"js/main.js.map":   {Data: []byte("map")},
+	"js/vendor/lib.js": {Data: []byte("lib")},
+}
+
+var referenceRE = regexp.MustCompile(`url\("?([^")]*)"?\)|sourceMappingURL=(\S+)`)
+
+// checkReferences requests filename and each reference in its content,
+// resolved relative to the hashed URL it was served from.
+func checkReferences(t *testing.T, s *Server, filename string, count int) {
+	t.Helper()
+	base, err := url.Parse("/" + s.Path(filename))
+	ensure.Nil(t, err)
+	get := func(u *url.URL) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest("GET", u.String(), nil))
+		ensure.DeepEqual(t, w.Code, http.StatusOK, u.String())
+		return w
+	}
+	refs := referenceRE.FindAllStringSubmatch(get(base).Body.String(), -1)
+	ensure.DeepEqual(t, len(refs), count)
+	for _, m := range refs {
+		ref, err := url.Parse(m[1] + m[2])
+		ensure.Nil(t, err)
+		get(base.ResolveReference(ref))
+	}
+}
+
+func TestNamerReferences(t *testing.T) {
+	cases := map[string][]Option{
+		"default":   nil,
+		"compound":  {WithCompoundExtensions(".js.map")},
+		"namer":     {WithNamer(dashNamer{})},
+		"directory": {WithDirectoryHash()},
+		"query":     {WithQueryVersion()},
+	}
+	for name, opts := range cases {
+		t.Run(name, func(t *testing.T) {
+			s := New(referencesFS, opts...)
+			checkReferences(t, s, "css/main.css", 2)
+			checkReferences(t, s, "js/main.js", 1)
+		})
+	}
+}
@@ -0,0 +1,125 @@
+package hashfs
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"time"
+)
+
+// ServeEvent describes a request served by a Server.
+type ServeEvent struct {
+	// Filename is the original filename of the served file, or empty if the
+	// request did not resolve to a file.
+	Filename string
+	// Hash is the encoded hash of the served file, if known.
+	Hash     string
+	Status   int
+	Bytes    int64
+	Duration time.Duration
+}
+
+// WithOnServe configures a function called after each request is served,
+// which is useful for logging and metrics. It may be used multiple times.
+func WithOnServe(f func(r *http.Request, e ServeEvent)) Option {
+	return func(s *Server) {
+		s.onServe = append(s.onServe, f)
+	}
+}
+
+// WithCacheObserver configures a function called for each lookup in the hash
+// cache, named "hash", and the content cache, named "content", reporting
+// whether it was a hit. It may be used multiple times.
+func WithCacheObserver(f func(cache string, hit bool)) Option {
+	return func(s *Server) {
+		s.cacheObservers = append(s.cacheObservers, f)
+	}
+}
+
+// WithHashObserver configures a function called after each file is hashed,
+// with the time hashing started and the time taken to read and hash it. The
+// context is that of the request which caused the file to be hashed, if any.
+// It may be used multiple times.
+func WithHashObserver(f func(ctx context.Context, filename string, start time.Time, d time.Duration)) Option {
+	return func(s *Server) {
+		s.hashObservers = append(s.hashObservers, f)
+	}
+}
+
+// observeCache counts a cache lookup and reports it to the cache observers.
+func (s *Server) observeCache(cache string, hit bool) {
+	switch {
+	case cache == "hash" && hit:
+		s.counters.hashHits.Add(1)
+	case cache == "hash":
+		s.counters.hashMisses.Add(1)
+	case hit:
+		s.counters.contentHits.Add(1)
+	default:
+		s.counters.contentMisses.Add(1)
+	}
+	for _, f := range s.cacheObservers {
+		f(cache, hit)
+	}
+}
+
+// serveRecorder records the response for a ServeEvent.
+type serveRecorder struct {
+	http.ResponseWriter
+	filename string
+	hash     string
+	status   int
+	bytes    int64
+}
+
+func (w *serveRecorder) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *serveRecorder) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// ReadFrom keeps the underlying writer's io.ReaderFrom, which net/http uses
+// to send *os.File contents with sendfile.
+func (w *serveRecorder) ReadFrom(src io.Reader) (int64, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	var n int64
+	var err error
+	if rf, ok := w.ResponseWriter.(io.ReaderFrom); ok {
+		n, err = rf.ReadFrom(src)
+	} else {
+		n, err = io.Copy(w.ResponseWriter, src)
+	}
+	w.bytes += n
+	return n, err
+}
+
+// Unwrap allows http.ResponseController to access the underlying writer.
+func (w *serveRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *serveRecorder) event(start time.Time) ServeEvent {
+	status := w.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	return ServeEvent{
+		Filename: w.filename,
+		Hash:     w.hash,
+		Status:   status,
+		Bytes:    w.bytes,
+		Duration: time.Since(start),
+	}
+}
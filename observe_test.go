@@ -0,0 +1,71 @@
+package hashfs
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/daaku/ensure"
+)
+
+func TestOnServe(t *testing.T) {
+	var events []ServeEvent
+	s := New(assets, WithOnServe(func(r *http.Request, e ServeEvent) {
+		ensure.True(t, e.Duration > 0)
+		e.Duration = 0
+		events = append(events, e)
+	}))
+	for _, p := range []string{"/" + hashedMainJS, "/assets/missing.000000000000.js"} {
+		s.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", p, nil))
+	}
+	ensure.DeepEqual(t, events, []ServeEvent{
+		{Filename: unhashedMainJS, Hash: "60797db6e8ff", Status: http.StatusOK, Bytes: 21},
+		{Status: http.StatusNotFound, Bytes: 10},
+	})
+}
+
+func TestOnServeReadFrom(t *testing.T) {
+	dir := t.TempDir()
+	data := bytes.Repeat([]byte("a"), 1<<20)
+	ensure.Nil(t, os.WriteFile(filepath.Join(dir, "large.bin"), data, 0o644))
+	events := make(chan ServeEvent, 1)
+	s := New(os.DirFS(dir), WithOnServe(func(r *http.Request, e ServeEvent) {
+		events <- e
+	}))
+	server := httptest.NewServer(s)
+	defer server.Close()
+
+	res, err := http.Get(server.URL + "/" + s.Path("large.bin"))
+	ensure.Nil(t, err)
+	body, err := io.ReadAll(res.Body)
+	ensure.Nil(t, err)
+	ensure.Nil(t, res.Body.Close())
+	ensure.DeepEqual(t, body, data)
+	e := <-events
+	ensure.DeepEqual(t, e.Status, http.StatusOK)
+	ensure.DeepEqual(t, e.Bytes, int64(len(data)))
+}
+
+func TestCacheObserver(t *testing.T) {
+	var lookups []string
+	var hashed []string
+	s := New(assets,
+		WithCacheObserver(func(cache string, hit bool) {
+			lookups = append(lookups, fmt.Sprint(cache, " ", hit))
+		}),
+		WithHashObserver(func(ctx context.Context, filename string, start time.Time, d time.Duration) {
+			hashed = append(hashed, filename)
+		}),
+	)
+	s.Path("assets/bar.txt")
+	s.Path("assets/bar.txt")
+	ensure.DeepEqual(t, lookups, []string{"hash false", "hash true"})
+	ensure.DeepEqual(t, hashed, []string{"assets/bar.txt"})
+}
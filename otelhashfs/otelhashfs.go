@@ -0,0 +1,82 @@
+// Package otelhashfs provides OpenTelemetry tracing for a hashfs.Server.
+package otelhashfs
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/daaku/hashfs"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/daaku/hashfs/otelhashfs"
+
+// Tracer creates spans for requests served by a hashfs.Server, with child
+// spans for files hashed while serving them.
+type Tracer struct {
+	tracer trace.Tracer
+}
+
+// New returns a Tracer using the TracerProvider.
+func New(tp trace.TracerProvider) *Tracer {
+	return &Tracer{tracer: tp.Tracer(instrumentationName)}
+}
+
+type stateKey struct{}
+
+// state collects the outcome of a request for its span.
+type state struct {
+	event  hashfs.ServeEvent
+	hashed bool
+}
+
+// Options returns the hashfs options which record the data for the spans.
+func (t *Tracer) Options() []hashfs.Option {
+	return []hashfs.Option{
+		hashfs.WithOnServe(func(r *http.Request, e hashfs.ServeEvent) {
+			if st, ok := r.Context().Value(stateKey{}).(*state); ok {
+				st.event = e
+			}
+		}),
+		hashfs.WithHashObserver(func(ctx context.Context, filename string, start time.Time, d time.Duration) {
+			if st, ok := ctx.Value(stateKey{}).(*state); ok {
+				st.hashed = true
+			}
+			_, span := t.tracer.Start(ctx, "hashfs.hash",
+				trace.WithTimestamp(start),
+				trace.WithAttributes(attribute.String("hashfs.filename", filename)),
+			)
+			span.End(trace.WithTimestamp(start.Add(d)))
+		}),
+	}
+}
+
+// Handler returns a handler which creates a span for each request served by
+// next, which should be the Server created with the Options.
+func (t *Tracer) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := t.tracer.Start(r.Context(), "hashfs.serve", trace.WithSpanKind(trace.SpanKindServer))
+		defer span.End()
+		st := &state{}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(ctx, stateKey{}, st)))
+
+		span.SetAttributes(attribute.Int("http.response.status_code", st.event.Status))
+		if st.event.Filename != "" {
+			cache := "hit"
+			if st.hashed {
+				cache = "miss"
+			}
+			span.SetAttributes(
+				attribute.String("hashfs.filename", st.event.Filename),
+				attribute.String("hashfs.hash", st.event.Hash),
+				attribute.String("hashfs.cache", cache),
+			)
+		}
+		if st.event.Status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(st.event.Status))
+		}
+	})
+}
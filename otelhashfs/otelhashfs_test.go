@@ -0,0 +1,36 @@
+package otelhashfs
+
+import (
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+
+	"github.com/daaku/ensure"
+	"github.com/daaku/hashfs"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTracer(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tr := New(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	s := hashfs.New(fstest.MapFS{"main.js": {Data: []byte("main")}}, tr.Options()...)
+	h := tr.Handler(s)
+	for range 2 {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/main.0d6e4079e367.js", nil))
+	}
+
+	spans := recorder.Ended()
+	ensure.DeepEqual(t, len(spans), 3)
+	ensure.DeepEqual(t, spans[0].Name(), "hashfs.hash")
+	ensure.DeepEqual(t, spans[0].Parent().SpanID(), spans[1].SpanContext().SpanID())
+	ensure.DeepEqual(t, spans[1].Name(), "hashfs.serve")
+	ensure.DeepEqual(t, spans[1].Attributes(), []attribute.KeyValue{
+		attribute.Int("http.response.status_code", 200),
+		attribute.String("hashfs.filename", "main.js"),
+		attribute.String("hashfs.hash", "0d6e4079e367"),
+		attribute.String("hashfs.cache", "miss"),
+	})
+	ensure.DeepEqual(t, spans[2].Attributes()[3], attribute.String("hashfs.cache", "hit"))
+}
@@ -0,0 +1,94 @@
+package hashfs
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"slices"
+	"strings"
+)
+
+// Overlay returns a file system which opens files from the first layer
+// containing them, so earlier layers shadow later ones. This allows a local
+// directory to shadow embedded files during development, or plugins to add
+// files. Directories list the entries of all layers.
+func Overlay(layers ...fs.FS) fs.FS {
+	return &overlayFS{layers: layers}
+}
+
+type overlayFS struct {
+	layers []fs.FS
+}
+
+func (o *overlayFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	for i, layer := range o.layers {
+		f, err := layer.Open(name)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		fi, err := f.Stat()
+		if err != nil || !fi.IsDir() {
+			return f, err
+		}
+		entries, err := o.readDir(name, o.layers[i:])
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		return &overlayDir{File: f, entries: entries}, nil
+	}
+	return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+}
+
+// readDir returns the merged entries of the directory in the layers, where
+// earlier layers shadow later ones.
+func (o *overlayFS) readDir(name string, layers []fs.FS) ([]fs.DirEntry, error) {
+	var merged []fs.DirEntry
+	seen := make(map[string]bool)
+	for _, layer := range layers {
+		entries, err := fs.ReadDir(layer, name)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			if !seen[e.Name()] {
+				seen[e.Name()] = true
+				merged = append(merged, e)
+			}
+		}
+	}
+	slices.SortFunc(merged, func(a, b fs.DirEntry) int {
+		return strings.Compare(a.Name(), b.Name())
+	})
+	return merged, nil
+}
+
+// overlayDir is a directory with the merged entries of all layers.
+type overlayDir struct {
+	fs.File
+	entries []fs.DirEntry
+	offset  int
+}
+
+func (d *overlayDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	rest := d.entries[d.offset:]
+	if n <= 0 {
+		d.offset = len(d.entries)
+		return rest, nil
+	}
+	if len(rest) == 0 {
+		return nil, io.EOF
+	}
+	n = min(n, len(rest))
+	d.offset += n
+	return rest[:n], nil
+}
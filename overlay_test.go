@@ -0,0 +1,30 @@
+package hashfs
+
+import (
+	"io/fs"
+	"testing"
+	"testing/fstest"
+
+	"github.com/daaku/ensure"
+)
+
+func TestOverlay(t *testing.T) {
+	local := fstest.MapFS{
+		"assets/main.js":  {Data: []byte("local")},
+		"assets/extra.js": {Data: []byte("extra")},
+	}
+	fsys := Overlay(local, assets)
+	ensure.Nil(t, fstest.TestFS(fsys, "assets/main.js", "assets/extra.js", "assets/bar.txt"))
+
+	content, err := fs.ReadFile(fsys, "assets/main.js")
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, string(content), "local")
+
+	s := New(fsys)
+	hashed := s.Path("assets/main.js")
+	ensure.DeepEqual(t, hashed, New(local).Path("assets/main.js"))
+	filename, err := s.Unhashed(hashed)
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, filename, "assets/main.js")
+	ensure.DeepEqual(t, s.Path("assets/bar.txt"), Path(assets, "assets/bar.txt"))
+}
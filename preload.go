@@ -0,0 +1,238 @@
+package hashfs
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/tdewolff/parse/v2"
+	"github.com/tdewolff/parse/v2/css"
+	"github.com/tdewolff/parse/v2/html"
+)
+
+// EarlyHints returns a middleware which sends a 103 Early Hints response with
+// Link preload headers for the hashed paths of the files returned by preload,
+// before calling next. The Link headers are also included in the final
+// response. Missing files are skipped.
+func (s *Server) EarlyHints(preload func(r *http.Request) []string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var sent bool
+		for _, filename := range preload(r) {
+			link, err := s.PreloadLink(filename)
+			if err != nil {
+				continue
+			}
+			w.Header().Add("Link", link)
+			sent = true
+		}
+		if sent {
+			w.WriteHeader(http.StatusEarlyHints)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// PreloadLink returns the value of a Link header to preload filename using its
+// hashed path, with the destination derived from the file extension.
+func (s *Server) PreloadLink(filename string) (string, error) {
+	p, err := s.preload(filename)
+	if err != nil {
+		return "", err
+	}
+	return p.Link(), nil
+}
+
+// Preload is a file to preload.
+type Preload struct {
+	Filename string // the original filename
+	URL      string // the hashed path
+	As       string // the request destination, which may be empty
+}
+
+// Link returns the value of a Link header to preload the file.
+func (p Preload) Link() string {
+	link := "<" + p.URL + ">; rel=preload"
+	if p.As != "" {
+		link += "; as=" + p.As
+		// fonts are always fetched in CORS mode
+		if p.As == "font" {
+			link += "; crossorigin"
+		}
+	}
+	return link
+}
+
+func (s *Server) preload(filename string) (Preload, error) {
+	hashed, err := s.EscapedPath(filename)
+	if err != nil {
+		return Preload{}, err
+	}
+	return Preload{Filename: filename, URL: hashed, As: preloadAs(filename)}, nil
+}
+
+// Preloads returns the scripts and stylesheets referenced by the HTML file
+// entry, in document order, for generating Link headers or preload tags.
+// References starting with "/" are resolved from the root of the file system,
+// after removing the prefix if one is configured, and others relative to the
+// HTML file. With recursive, the files referenced by stylesheets, such as
+// fonts, images and imported stylesheets, are included as well. References to
+// missing files are skipped.
+func (s *Server) Preloads(entry string, recursive bool) ([]Preload, error) {
+	content, err := fs.ReadFile(s.fs, entry)
+	if err != nil {
+		return nil, fmt.Errorf("hashfs: error opening file: %w", err)
+	}
+	var preloads []Preload
+	seen := make(map[string]bool)
+	var add func(filename string)
+	add = func(filename string) {
+		if seen[filename] {
+			return
+		}
+		seen[filename] = true
+		p, err := s.preload(filename)
+		if err != nil {
+			return
+		}
+		preloads = append(preloads, p)
+		if recursive && isCSSFilename(filename) {
+			for _, ref := range s.cssReferences(filename) {
+				if name, ok := resolveReference(path.Dir(filename), ref, ""); ok {
+					add(name)
+				}
+			}
+		}
+	}
+	for _, ref := range htmlReferences(content) {
+		if name, ok := resolveReference(path.Dir(entry), ref, s.prefix); ok {
+			add(name)
+		}
+	}
+	return preloads, nil
+}
+
+// resolveReference returns the filename for a reference found in a file in
+// dir. References with a scheme or host are not resolved.
+func resolveReference(dir, ref, prefix string) (string, bool) {
+	if i := strings.IndexAny(ref, "?#"); i >= 0 {
+		ref = ref[:i]
+	}
+	if ref == "" || strings.Contains(ref, ":") || strings.HasPrefix(ref, "//") {
+		return "", false
+	}
+	ref, err := url.PathUnescape(ref)
+	if err != nil {
+		return "", false
+	}
+	if strings.HasPrefix(ref, "/") {
+		if prefix != "" {
+			var found bool
+			if ref, found = strings.CutPrefix(ref, prefix); !found {
+				return "", false
+			}
+		}
+		return path.Clean(strings.TrimPrefix(ref, "/")), true
+	}
+	return path.Join(dir, ref), true
+}
+
+// htmlReferences returns the scripts and stylesheets referenced by the HTML.
+func htmlReferences(content []byte) []string {
+	var refs []string
+	var tag string
+	attrs := make(map[string]string)
+	l := html.NewLexer(parse.NewInputBytes(content))
+	for {
+		tt, _ := l.Next()
+		switch tt {
+		case html.ErrorToken:
+			return refs
+		case html.StartTagToken:
+			tag = strings.ToLower(string(l.Text()))
+			clear(attrs)
+		case html.AttributeToken:
+			val := string(l.AttrVal())
+			if len(val) > 1 && (val[0] == '"' || val[0] == '\'') {
+				val = val[1 : len(val)-1]
+			}
+			attrs[strings.ToLower(string(l.AttrKey()))] = val
+		case html.StartTagCloseToken, html.StartTagVoidToken:
+			switch tag {
+			case "script":
+				if src := attrs["src"]; src != "" {
+					refs = append(refs, src)
+				}
+			case "link":
+				rel := strings.Fields(strings.ToLower(attrs["rel"]))
+				if slices.Contains(rel, "stylesheet") || slices.Contains(rel, "preload") || slices.Contains(rel, "modulepreload") {
+					if href := attrs["href"]; href != "" {
+						refs = append(refs, href)
+					}
+				}
+			}
+			tag = ""
+		}
+	}
+}
+
+// cssReferences returns the files referenced by url() and @import in the CSS
+// file.
+func (s *Server) cssReferences(filename string) []string {
+	f, err := s.fs.Open(filename)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	var refs []string
+	var imported bool
+	l := css.NewLexer(parse.NewInput(f))
+	for {
+		tt, text := l.Next()
+		switch tt {
+		case css.ErrorToken:
+			return refs
+		case css.AtKeywordToken:
+			imported = bytes.EqualFold(text, []byte("@import"))
+		case css.StringToken:
+			if ref, ok := cssString(text); imported && ok {
+				refs = append(refs, ref)
+			}
+			imported = false
+		case css.URLToken:
+			ref := bytes.TrimSuffix(bytes.TrimPrefix(text, urlBarePre), urlBarePost)
+			ref = bytes.Trim(bytes.TrimSpace(ref), `"'`)
+			refs = append(refs, string(ref))
+			imported = false
+		case css.WhitespaceToken, css.CommentToken:
+		default:
+			imported = false
+		}
+	}
+}
+
+// preloadAs returns the request destination for preloading filename.
+func preloadAs(filename string) string {
+	ext := path.Ext(filename)
+	switch ext {
+	case ".js", ".mjs":
+		return "script"
+	case ".css":
+		return "style"
+	case ".json":
+		return "fetch"
+	}
+	mediaType, _, _ := strings.Cut(mime.TypeByExtension(ext), ";")
+	switch {
+	case strings.HasPrefix(mediaType, "font/"):
+		return "font"
+	case strings.HasPrefix(mediaType, "image/"):
+		return "image"
+	}
+	return ""
+}
@@ -0,0 +1,117 @@
+package hashfs
+
+import (
+	"errors"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"net/textproto"
+	"testing"
+	"testing/fstest"
+
+	"github.com/daaku/ensure"
+)
+
+func TestPreloadLink(t *testing.T) {
+	s := New(fstest.MapFS{
+		"main.js":    {Data: []byte("main")},
+		"main.css":   {Data: []byte("a {}")},
+		"font.woff2": {Data: []byte("font")},
+		"other.bin":  {Data: []byte("other")},
+	}, WithPrefix("/static/"))
+	cases := []struct {
+		filename, link string
+	}{
+		{"main.js", "</static/main.0d6e4079e367.js>; rel=preload; as=script"},
+		{"main.css", "</static/main.9a4487ccbedf.css>; rel=preload; as=style"},
+		{"font.woff2", "</static/font.795ea3efa43d.woff2>; rel=preload; as=font; crossorigin"},
+		{"other.bin", "</static/other.d9298a10d1b0.bin>; rel=preload"},
+	}
+	for _, c := range cases {
+		link, err := s.PreloadLink(c.filename)
+		ensure.Nil(t, err)
+		ensure.DeepEqual(t, link, c.link)
+	}
+}
+
+func TestEarlyHints(t *testing.T) {
+	s := New(fstest.MapFS{"main.js": {Data: []byte("main")}})
+	h := s.EarlyHints(func(r *http.Request) []string {
+		return []string{"main.js", "missing.js"}
+	}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("page"))
+	}))
+
+	var informational []int
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+	req, err := http.NewRequest("GET", srv.URL, nil)
+	ensure.Nil(t, err)
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), &httptrace.ClientTrace{
+		Got1xxResponse: func(code int, header textproto.MIMEHeader) error {
+			informational = append(informational, code)
+			return nil
+		},
+	}))
+	res, err := http.DefaultClient.Do(req)
+	ensure.Nil(t, err)
+	res.Body.Close()
+	ensure.DeepEqual(t, informational, []int{http.StatusEarlyHints})
+	ensure.DeepEqual(t, res.Header.Values("Link"), []string{"<main.0d6e4079e367.js>; rel=preload; as=script"})
+}
+
+func TestPreloads(t *testing.T) {
+	s := New(fstest.MapFS{
+		"index.html": {Data: []byte(`<!doctype html>
+<link rel="stylesheet" href="/static/css/main.css">
+<link rel=icon href="/static/favicon.ico">
+<script src="app/main.js?x=1" defer></script>
+<script src="https://example.com/ext.js"></script>
+<script src="/static/missing.js"></script>
+<script src="/static/app/main.js"></script>`)},
+		"css/main.css":    {Data: []byte(`@import "base.css"; a { background: url(../img/a.png) }`)},
+		"css/base.css":    {Data: []byte(`@font-face { src: url("../font.woff2") }`)},
+		"app/main.js":     {Data: []byte("main")},
+		"img/a.png":       {Data: []byte("png")},
+		"font.woff2":      {Data: []byte("font")},
+		"favicon.ico":     {Data: []byte("icon")},
+		"unreferenced.js": {Data: []byte("unreferenced")},
+	}, WithPrefix("/static/"))
+
+	filenames := func(preloads []Preload) []string {
+		var names []string
+		for _, p := range preloads {
+			url, err := s.MaybePath(p.Filename)
+			ensure.Nil(t, err)
+			ensure.DeepEqual(t, p.URL, url)
+			ensure.DeepEqual(t, p.As, preloadAs(p.Filename))
+			names = append(names, p.Filename)
+		}
+		return names
+	}
+
+	preloads, err := s.Preloads("index.html", false)
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, filenames(preloads), []string{"css/main.css", "app/main.js"})
+
+	preloads, err = s.Preloads("index.html", true)
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, filenames(preloads), []string{
+		"css/main.css", "css/base.css", "font.woff2", "img/a.png", "app/main.js",
+	})
+	ensure.DeepEqual(t, preloads[2].Link(), "<"+preloads[2].URL+">; rel=preload; as=font; crossorigin")
+
+	_, err = s.Preloads("missing.html", false)
+	ensure.True(t, errors.Is(err, fs.ErrNotExist))
+}
+
+func TestPreloadsUnterminatedString(t *testing.T) {
+	s := New(fstest.MapFS{
+		"index.html": {Data: []byte(`<link rel="stylesheet" href="main.css">`)},
+		"main.css":   {Data: []byte(`@import "`)},
+	})
+	preloads, err := s.Preloads("index.html", true)
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, len(preloads), 1)
+}
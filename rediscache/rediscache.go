@@ -0,0 +1,45 @@
+// Package rediscache provides a hashfs.Cache backed by Redis, for sharing
+// hashes between instances serving the same files.
+package rediscache
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/daaku/hashfs"
+	"github.com/redis/go-redis/v9"
+)
+
+// Cache stores hashes in Redis.
+type Cache struct {
+	client redis.UniversalClient
+	ttl    time.Duration
+}
+
+var _ hashfs.Cache = (*Cache)(nil)
+
+// New returns a Cache using the client. Entries expire after ttl, or never if
+// it is zero. Since keys include the size and modification time of files,
+// entries for changed files are never used again and a ttl lets Redis evict
+// them.
+func New(client redis.UniversalClient, ttl time.Duration) *Cache {
+	return &Cache{client: client, ttl: ttl}
+}
+
+// Get returns the value for the key, and false if it was not found.
+func (c *Cache) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	v, err := c.client.Get(ctx, key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return v, true, nil
+}
+
+// Set stores the value for the key.
+func (c *Cache) Set(ctx context.Context, key string, value []byte) error {
+	return c.client.Set(ctx, key, value, c.ttl).Err()
+}
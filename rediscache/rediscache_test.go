@@ -0,0 +1,34 @@
+package rediscache
+
+import (
+	"context"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/daaku/ensure"
+	"github.com/daaku/hashfs"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestCache(t *testing.T) {
+	mr := miniredis.RunT(t)
+	c := New(redis.NewClient(&redis.Options{Addr: mr.Addr()}), time.Hour)
+	ctx := context.Background()
+
+	_, found, err := c.Get(ctx, "missing")
+	ensure.Nil(t, err)
+	ensure.False(t, found)
+	ensure.Nil(t, c.Set(ctx, "key", []byte("value")))
+	v, found, err := c.Get(ctx, "key")
+	ensure.Nil(t, err)
+	ensure.True(t, found)
+	ensure.DeepEqual(t, v, []byte("value"))
+	ensure.DeepEqual(t, mr.TTL("key"), time.Hour)
+
+	fsys := fstest.MapFS{"a.txt": {Data: []byte("a"), ModTime: time.Unix(1700000000, 0)}}
+	a := hashfs.New(fsys, hashfs.WithCache(c)).Path("a.txt")
+	ensure.DeepEqual(t, len(mr.Keys()), 2)
+	ensure.DeepEqual(t, hashfs.New(fsys, hashfs.WithCache(c)).Path("a.txt"), a)
+}
@@ -0,0 +1,70 @@
+package hashfs
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"time"
+)
+
+// Cache is a cache of hashes shared by Servers, such as one backed by Redis,
+// so instances serving the same network hosted files reuse each other's work.
+type Cache interface {
+	// Get returns the value for the key, and false if it was not found.
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+	// Set stores the value for the key.
+	Set(ctx context.Context, key string, value []byte) error
+}
+
+// WithCache consults the shared cache before hashing files, and stores the
+// hashes it computes. Entries are keyed by the hash function, filename, size
+// and modification time of files. Like WithHashCacheFile, files without a
+// modification time, and files whose hash depends on other files, such as CSS
+// with relative references, are always hashed. Errors from the cache are
+// ignored, and files are hashed as if it missed.
+func WithCache(c Cache) Option {
+	return func(s *Server) {
+		s.cache = c
+	}
+}
+
+// cacheKey returns the shared cache key for filename, and false if it cannot
+// be cached.
+func (s *Server) cacheKey(filename string, size int64, modTime time.Time) (string, bool) {
+	if modTime.IsZero() {
+		return "", false
+	}
+	return fmt.Sprintf("hashfs:%s:%d:%d:%s", s.hashCheck(), size, modTime.UnixNano(), filename), true
+}
+
+// lookupCache returns the hash for filename from the shared cache.
+func (s *Server) lookupCache(ctx context.Context, filename string) (*hashEntry, bool) {
+	if s.hasImageVariants(filename) {
+		return nil, false
+	}
+	fi, err := fs.Stat(s.fs, filename)
+	if err != nil {
+		return nil, false
+	}
+	key, ok := s.cacheKey(filename, fi.Size(), fi.ModTime())
+	if !ok {
+		return nil, false
+	}
+	sum, found, err := s.cache.Get(ctx, key)
+	if err != nil || !found || len(sum) == 0 {
+		return nil, false
+	}
+	e := s.entry(filename, s.digest(sum))
+	e.modTime, e.size, e.checked = fi.ModTime(), fi.Size(), time.Now()
+	return e, true
+}
+
+// storeCache stores the hash of filename in the shared cache.
+func (s *Server) storeCache(ctx context.Context, filename string, e *hashEntry) {
+	if e.derived {
+		return
+	}
+	if key, ok := s.cacheKey(filename, e.size, e.modTime); ok {
+		_ = s.cache.Set(ctx, key, e.digest)
+	}
+}
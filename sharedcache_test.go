@@ -0,0 +1,64 @@
+package hashfs
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/daaku/ensure"
+)
+
+type mapCache struct {
+	mu   sync.Mutex
+	m    map[string][]byte
+	fail bool
+}
+
+func (c *mapCache) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.fail {
+		return nil, false, errors.New("unavailable")
+	}
+	v, found := c.m[key]
+	return v, found, nil
+}
+
+func (c *mapCache) Set(ctx context.Context, key string, value []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.fail {
+		return errors.New("unavailable")
+	}
+	c.m[key] = value
+	return nil
+}
+
+func TestCache(t *testing.T) {
+	modTime := time.Unix(1700000000, 0)
+	fsys := fstest.MapFS{
+		"a.txt":    {Data: []byte("aaaa"), ModTime: modTime},
+		"main.css": {Data: []byte(`@import "a.txt";`), ModTime: modTime},
+	}
+	cache := &mapCache{m: map[string][]byte{}}
+	a := New(fsys, WithCache(cache)).Path("a.txt")
+	New(fsys, WithCache(cache)).Path("main.css")
+	ensure.DeepEqual(t, len(cache.m), 1)
+
+	// another instance uses the hash while the size and modification time are
+	// unchanged
+	fsys["a.txt"] = &fstest.MapFile{Data: []byte("bbbb"), ModTime: modTime}
+	ensure.DeepEqual(t, New(fsys, WithCache(cache)).Path("a.txt"), a)
+
+	fsys["a.txt"] = &fstest.MapFile{Data: []byte("bbbb"), ModTime: modTime.Add(time.Second)}
+	b := New(fsys, WithCache(cache)).Path("a.txt")
+	ensure.NotDeepEqual(t, b, a)
+	ensure.DeepEqual(t, len(cache.m), 2)
+
+	// errors fall back to hashing
+	cache.fail = true
+	ensure.DeepEqual(t, New(fsys, WithCache(cache)).Path("a.txt"), b)
+}
@@ -0,0 +1,88 @@
+package hashfs
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrInvalidSignature is returned when a file which requires a signed URL is
+// requested without a valid signature, or after the URL expired.
+var ErrInvalidSignature = errors.New("hashfs: invalid signature")
+
+// WithSignedURLs requires URLs generated by SignedPath to serve files matching
+// the patterns, for time limited access to files such as paid downloads. The
+// first key signs URLs, and all keys are accepted when verifying, which allows
+// rotating keys. Patterns use the same syntax as WithPassthrough. Responses are
+// cached privately until the URL expires, and requests without a valid
+// signature fail with ErrInvalidSignature, using 403 Forbidden by default.
+func WithSignedURLs(patterns []string, keys ...[]byte) Option {
+	return func(s *Server) {
+		s.signed = &signer{patterns: patterns, keys: keys}
+	}
+}
+
+type signer struct {
+	patterns []string
+	keys     [][]byte
+}
+
+// sign returns the signature for the hashed path expiring at the unix time.
+func (*signer) sign(key []byte, hashed string, expires int64) string {
+	mac := hmac.New(sha256.New, key)
+	fmt.Fprintf(mac, "%s\n%d", hashed, expires)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// SignedPath returns the hashed path of filename like EscapedPath, with an
+// expiry and a signature required by WithSignedURLs.
+func (s *Server) SignedPath(filename string, expires time.Time) (string, error) {
+	if s.signed == nil || len(s.signed.keys) == 0 {
+		return "", fmt.Errorf("hashfs: signed URLs not configured")
+	}
+	escaped, err := s.EscapedPath(filename)
+	if err != nil {
+		return "", err
+	}
+	e, err := s.hash(filename)
+	if err != nil {
+		return "", err
+	}
+	sep := "?"
+	if strings.Contains(escaped, "?") {
+		sep = "&"
+	}
+	sig := s.signed.sign(s.signed.keys[0], e.path, expires.Unix())
+	return fmt.Sprintf("%s%sexpires=%d&signature=%s", escaped, sep, expires.Unix(), sig), nil
+}
+
+// verifySignature checks the signature of the request for filename if one is
+// required, and returns how long the response may be cached.
+func (s *Server) verifySignature(r *http.Request, filename string) (time.Duration, error) {
+	e, err := s.hashContext(r.Context(), filename)
+	if err != nil {
+		return 0, err
+	}
+	q := r.URL.Query()
+	expires, err := strconv.ParseInt(q.Get("expires"), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w for %q: missing expiry", ErrInvalidSignature, filename)
+	}
+	ttl := time.Until(time.Unix(expires, 0))
+	if ttl <= 0 {
+		return 0, fmt.Errorf("%w for %q: expired", ErrInvalidSignature, filename)
+	}
+	sig := q.Get("signature")
+	for _, key := range s.signed.keys {
+		if hmac.Equal([]byte(sig), []byte(s.signed.sign(key, e.path, expires))) {
+			return ttl, nil
+		}
+	}
+	return 0, fmt.Errorf("%w for %q", ErrInvalidSignature, filename)
+}
@@ -0,0 +1,66 @@
+package hashfs
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/daaku/ensure"
+)
+
+var signedFS = fstest.MapFS{
+	"downloads/book.pdf": {Data: []byte("book")},
+	"main.txt":           {Data: []byte("main")},
+}
+
+func TestSignedURLs(t *testing.T) {
+	oldKey, newKey := []byte("old"), []byte("new")
+	old := New(signedFS, WithSignedURLs([]string{"downloads/*"}, oldKey))
+	s := New(signedFS, WithSignedURLs([]string{"downloads/*"}, newKey, oldKey))
+
+	signed, err := s.SignedPath("downloads/book.pdf", time.Now().Add(time.Hour))
+	ensure.Nil(t, err)
+	ensure.True(t, strings.HasPrefix(signed, s.Path("downloads/book.pdf")+"?expires="))
+	oldSigned, err := old.SignedPath("downloads/book.pdf", time.Now().Add(time.Hour))
+	ensure.Nil(t, err)
+	expired, err := s.SignedPath("downloads/book.pdf", time.Now().Add(-time.Minute))
+	ensure.Nil(t, err)
+
+	cases := []struct {
+		path string
+		code int
+	}{
+		{signed, http.StatusOK},
+		{oldSigned, http.StatusOK},
+		{expired, http.StatusForbidden},
+		{s.Path("downloads/book.pdf"), http.StatusForbidden},
+		{strings.Replace(signed, "signature=", "signature=x", 1), http.StatusForbidden},
+		{strings.Replace(signed, "expires=", "expires=1", 1), http.StatusForbidden},
+		{s.Path("main.txt"), http.StatusOK},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest("GET", "/"+c.path, nil)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		ensure.DeepEqual(t, w.Code, c.code, c.path)
+	}
+
+	r := httptest.NewRequest("GET", "/"+signed, nil)
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	ensure.True(t, strings.HasPrefix(w.Header().Get("Cache-Control"), "private, max-age=35"))
+
+	// signed with a key no longer accepted
+	r = httptest.NewRequest("GET", "/"+signed, nil)
+	w = httptest.NewRecorder()
+	old.ServeHTTP(w, r)
+	ensure.DeepEqual(t, w.Code, http.StatusForbidden)
+}
+
+func TestSignedPathNotConfigured(t *testing.T) {
+	_, err := New(signedFS).SignedPath("main.txt", time.Now())
+	ensure.NotNil(t, err)
+}
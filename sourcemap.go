@@ -0,0 +1,134 @@
+package hashfs
+
+import (
+	"bytes"
+	"context"
+	"io/fs"
+	"path"
+	"strings"
+)
+
+const sourceMappingURL = "sourceMappingURL="
+
+func isJSFilename(filename string) bool {
+	ext := path.Ext(filename)
+	return ext == ".js" || ext == ".mjs"
+}
+
+// isRewritable reports if the content served for filename may be rewritten to
+// contain the hashed paths of other files.
+func isRewritable(filename string) bool {
+	return isCSSFilename(filename) || isJSFilename(filename)
+}
+
+// rewritten returns the rewritten content for filename, if it has any.
+func (s *Server) rewritten(filename string) (string, bool) {
+	r := s.rewriteContext(context.Background(), filename)
+	return r.content, r.ok
+}
+
+// rewriteContext rewrites filename with the context the referenced files are
+// hashed with. The files being rewritten are tracked in the context, so a
+// reference cycle is left unrewritten instead of waiting on itself.
+func (s *Server) rewriteContext(ctx context.Context, filename string) rewrite {
+	ctx = context.WithValue(ctx, rewritingKey{}, &rewriting{filename: filename, parent: rewritingFrom(ctx)})
+	if isCSSFilename(filename) {
+		r, err := s.hashCSSAssets(ctx, filename)
+		if err != nil {
+			return rewrite{}
+		}
+		return r
+	}
+	if isJSFilename(filename) {
+		return s.hashJSSourceMap(ctx, filename)
+	}
+	return rewrite{}
+}
+
+type rewritingKey struct{}
+
+// rewriting is a file whose content is being rewritten, and the file whose
+// rewriting referenced it.
+type rewriting struct {
+	filename   string
+	parent     *rewriting
+	referenced bool // a relative reference was found, even if not rewritten
+}
+
+func rewritingFrom(ctx context.Context) *rewriting {
+	r, _ := ctx.Value(rewritingKey{}).(*rewriting)
+	return r
+}
+
+// references reports if a relative reference was found while rewriting.
+func (r *rewriting) references() bool {
+	return r != nil && r.referenced
+}
+
+// contains reports if filename is being rewritten.
+func (r *rewriting) contains(filename string) bool {
+	for ; r != nil; r = r.parent {
+		if r.filename == filename {
+			return true
+		}
+	}
+	return false
+}
+
+// rewrite is the rewritten content of a file, and whether it differs from the
+// content of the file. A file with references depends on the files they
+// resolve to, or may resolve to later, even if it was not rewritten.
+type rewrite struct {
+	content string
+	ok      bool
+	refs    bool
+}
+
+// hashJSSourceMap returns the content of the JavaScript file with the trailing
+// sourceMappingURL comment rewritten to the hashed path of the source map. It
+// is not ok if there is nothing to rewrite.
+func (s *Server) hashJSSourceMap(ctx context.Context, filename string) rewrite {
+	cached, found := s.js.Load(filename)
+	if found {
+		return cached.(rewrite)
+	}
+
+	var r rewrite
+	gen := s.generation.Load()
+	b, err := fs.ReadFile(s.fs, filename)
+	if err != nil {
+		return r
+	}
+	// only the last line is converted, to avoid copying files without a
+	// source map reference
+	lineStart := bytes.LastIndexByte(bytes.TrimRight(b, "\r\n"), '\n') + 1
+	if last := b[lineStart:]; bytes.HasPrefix(last, []byte("//# ")) || bytes.HasPrefix(last, []byte("//@ ")) {
+		line := string(last)
+		if rewritten := s.rewriteSourceMap(ctx, filename, line); rewritten != line {
+			r = rewrite{content: string(b[:lineStart]) + rewritten, ok: true}
+		}
+	}
+	r.refs = rewritingFrom(ctx).references()
+	// references are left unrewritten if the context is canceled
+	if !s.noCache && s.generation.Load() == gen && ctx.Err() == nil {
+		s.js.Store(filename, r)
+	}
+	return r
+}
+
+// rewriteSourceMap rewrites the source map reference in a comment, such as
+// "//# sourceMappingURL=main.js.map" or "/*# sourceMappingURL=main.css.map */",
+// to the hashed path of the source map.
+func (s *Server) rewriteSourceMap(ctx context.Context, basepath, comment string) string {
+	i := strings.Index(comment, sourceMappingURL)
+	if i < 0 {
+		return comment
+	}
+	start := i + len(sourceMappingURL)
+	end := len(comment)
+	if j := strings.IndexAny(comment[start:], " \t\r\n*"); j >= 0 {
+		end = start + j
+	}
+	target := comment[start:end]
+	return comment[:start] + s.transformPath(ctx, basepath, target) + comment[end:]
+}
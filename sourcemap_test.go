@@ -0,0 +1,79 @@
+package hashfs
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+
+	"github.com/daaku/ensure"
+)
+
+func TestSourceMap(t *testing.T) {
+	fsys := fstest.MapFS{
+		"js/main.js":       {Data: []byte("alert(1)\n//# sourceMappingURL=main.js.map\n")},
+		"js/main.js.map":   {Data: []byte("{}")},
+		"js/plain.js":      {Data: []byte("alert(2)\n")},
+		"js/missing.js":    {Data: []byte("alert(3)\n//# sourceMappingURL=missing.js.map")},
+		"css/main.css":     {Data: []byte("a{}\n/*# sourceMappingURL=main.css.map */\n")},
+		"css/main.css.map": {Data: []byte("[]")},
+	}
+	s := New(fsys)
+	cases := []struct {
+		name, body string
+	}{
+		{"js/main.js", "alert(1)\n//# sourceMappingURL=main.js.44136fa355b3.map\n"},
+		{"js/plain.js", "alert(2)\n"},
+		{"js/missing.js", "alert(3)\n//# sourceMappingURL=missing.js.map"},
+		{"css/main.css", "a{}\n/*# sourceMappingURL=main.css.4f53cda18c2b.map */\n"},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest("GET", "/"+s.Path(c.name), nil)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		ensure.DeepEqual(t, w.Code, http.StatusOK)
+		ensure.DeepEqual(t, w.Body.String(), c.body)
+	}
+
+	r := httptest.NewRequest("GET", "/js/main.js.44136fa355b3.map", nil)
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	ensure.DeepEqual(t, w.Code, http.StatusOK)
+	ensure.DeepEqual(t, w.Body.String(), "{}")
+}
+
+func TestSourceMapPrecompressed(t *testing.T) {
+	fsys := fstest.MapFS{
+		"app.js":     {Data: []byte("alert(1)\n//# sourceMappingURL=app.js.map\n")},
+		"app.js.map": {Data: []byte("{}")},
+		"app.js.br":  {Data: []byte("stale br")},
+		"app.js.gz":  {Data: []byte("stale gz")},
+	}
+	s := New(fsys)
+	body := "alert(1)\n//# sourceMappingURL=app.js.44136fa355b3.map\n"
+	cases := []struct {
+		acceptEncoding, encoding string
+	}{
+		{"br", ""},
+		{"br, gzip", "gzip"},
+		{"", ""},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest("GET", "/"+s.Path("app.js"), nil)
+		r.Header.Set("Accept-Encoding", c.acceptEncoding)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		ensure.DeepEqual(t, w.Code, http.StatusOK)
+		ensure.DeepEqual(t, w.Header().Get("Content-Encoding"), c.encoding)
+		content := w.Body.Bytes()
+		if c.encoding == "gzip" {
+			zr, err := gzip.NewReader(w.Body)
+			ensure.Nil(t, err)
+			content, err = io.ReadAll(zr)
+			ensure.Nil(t, err)
+		}
+		ensure.DeepEqual(t, string(content), body)
+	}
+}
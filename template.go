@@ -0,0 +1,58 @@
+package hashfs
+
+import (
+	"fmt"
+	"html/template"
+	"io/fs"
+)
+
+// FuncMap returns template functions for the default Server of the file
+// system. See Server.FuncMap.
+func FuncMap(fs fs.FS) map[string]any {
+	return defaultServer(fs).FuncMap()
+}
+
+// FuncMap returns functions for use with html/template and text/template:
+//
+//	asset:         returns the escaped hashed path of a file, see EscapedPath
+//	assetURL:      returns the escaped hashed path of a file as a template.URL
+//	scriptTag:     returns a script tag for a file, see ScriptTag
+//	stylesheetTag: returns a stylesheet link tag for a file, see StylesheetTag
+//
+// Missing files result in template execution errors rather than panics.
+func (s *Server) FuncMap() map[string]any {
+	return map[string]any{
+		"asset": s.EscapedPath,
+		"assetURL": func(filename string) (template.URL, error) {
+			hashed, err := s.EscapedPath(filename)
+			return template.URL(hashed), err
+		},
+		"scriptTag":     s.ScriptTag,
+		"stylesheetTag": s.StylesheetTag,
+	}
+}
+
+// ScriptTag returns a script tag for filename using its escaped hashed path
+// along with the matching integrity and crossorigin attributes.
+func (s *Server) ScriptTag(filename string) (template.HTML, error) {
+	return s.tag(`<script src="%s" integrity="%s" crossorigin="anonymous"></script>`, filename)
+}
+
+// StylesheetTag returns a stylesheet link tag for filename using its escaped
+// hashed path along with the matching integrity and crossorigin attributes.
+func (s *Server) StylesheetTag(filename string) (template.HTML, error) {
+	return s.tag(`<link rel="stylesheet" href="%s" integrity="%s" crossorigin="anonymous">`, filename)
+}
+
+func (s *Server) tag(format, filename string) (template.HTML, error) {
+	hashed, err := s.EscapedPath(filename)
+	if err != nil {
+		return "", err
+	}
+	integrity, err := s.Integrity(filename)
+	if err != nil {
+		return "", err
+	}
+	return template.HTML(fmt.Sprintf(format,
+		template.HTMLEscapeString(hashed), template.HTMLEscapeString(integrity))), nil
+}
@@ -0,0 +1,62 @@
+package hashfs
+
+import (
+	"html/template"
+	"regexp"
+	"strings"
+	"testing"
+	"testing/fstest"
+	ttemplate "text/template"
+
+	"github.com/daaku/ensure"
+)
+
+func TestFuncMapHTML(t *testing.T) {
+	tmpl := template.Must(template.New("").Funcs(FuncMap(assets)).Parse(
+		`<script src="{{asset "assets/main.js"}}"></script><a href="{{assetURL "assets/foo"}}">`))
+	var out strings.Builder
+	ensure.Nil(t, tmpl.Execute(&out, nil))
+	ensure.DeepEqual(t, out.String(),
+		`<script src="assets/main.60797db6e8ff.js"></script><a href="assets/foo.b5bb9d8014a0">`)
+}
+
+func TestFuncMapText(t *testing.T) {
+	tmpl := ttemplate.Must(ttemplate.New("").Funcs(FuncMap(assets)).Parse(`{{asset "assets/main.js"}}`))
+	var out strings.Builder
+	ensure.Nil(t, tmpl.Execute(&out, nil))
+	ensure.DeepEqual(t, out.String(), hashedMainJS)
+}
+
+func TestFuncMapMissing(t *testing.T) {
+	tmpl := template.Must(template.New("").Funcs(FuncMap(assets)).Parse(`{{asset "missing.js"}}`))
+	err := tmpl.Execute(&strings.Builder{}, nil)
+	ensure.Err(t, err, regexp.MustCompile("hashfs: error opening file"))
+}
+
+func TestTags(t *testing.T) {
+	tmpl := template.Must(template.New("").Funcs(FuncMap(assets)).Parse(
+		`{{scriptTag "assets/main.js"}}{{stylesheetTag "assets/boom.css"}}`))
+	var out strings.Builder
+	ensure.Nil(t, tmpl.Execute(&out, nil))
+	ensure.DeepEqual(t, out.String(), `<script src="assets/main.60797db6e8ff.js" `+
+		`integrity="sha256-YHl9tuj/MtoXfyCKy4Cp/G90fPu+kKER6mpyVrUSBY8=" crossorigin="anonymous"></script>`+
+		`<link rel="stylesheet" href="assets/boom.8d7a531d714c.css" `+
+		`integrity="sha256-jXpTHXFMS9cSG/fWOcYZH/ZJWk8RMsmuPN1nK+AWiVQ=" crossorigin="anonymous">`)
+}
+
+func TestTagEscaping(t *testing.T) {
+	fsys := fstest.MapFS{`a"b.js`: {Data: []byte("a")}}
+	tag, err := New(fsys).ScriptTag(`a"b.js`)
+	ensure.Nil(t, err)
+	ensure.StringContains(t, string(tag), `src="a%22b.ca978112ca1b.js"`)
+}
+
+func TestFuncMapEscaping(t *testing.T) {
+	fsys := fstest.MapFS{"a#b c.js": {Data: []byte("main")}}
+	tmpl := template.Must(template.New("").Funcs(FuncMap(fsys)).Parse(
+		`<a href="{{asset "a#b c.js"}}"></a>{{assetURL "a#b c.js"}}{{scriptTag "a#b c.js"}}`))
+	var out strings.Builder
+	ensure.Nil(t, tmpl.Execute(&out, nil))
+	ensure.DeepEqual(t, out.String(), `<a href="a%23b%20c.0d6e4079e367.js"></a>a%23b%20c.0d6e4079e367.js`+
+		`<script src="a%23b%20c.0d6e4079e367.js" integrity="sha256-DW5AeeNnA+vTfAByL1iR0osOKBHcEUsSkhUSOtzONgU=" crossorigin="anonymous"></script>`)
+}
@@ -0,0 +1,75 @@
+package hashfs
+
+import (
+	"fmt"
+	"io/fs"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// VersionedMount serves several versions of assets side by side. Each version
+// has its own file system and Server, so relative references resolve within
+// the version and caches are never shared. Requests are routed by their first
+// path segment, which is the version id.
+type VersionedMount struct {
+	opts     []Option
+	mu       sync.RWMutex
+	versions map[string]*Server
+}
+
+// NewVersionedMount returns an empty VersionedMount. The options are used for
+// the Server of every version, which is mounted at "/<id>/" using WithPrefix. A
+// base URL in the options is followed by the version id.
+func NewVersionedMount(opts ...Option) *VersionedMount {
+	return &VersionedMount{
+		opts:     opts,
+		versions: make(map[string]*Server),
+	}
+}
+
+// AddVersion adds or replaces the version with the given id.
+func (m *VersionedMount) AddVersion(id string, fs fs.FS) {
+	s := New(fs, append(slices.Clip(m.opts), WithPrefix("/"+id+"/"))...)
+	if s.baseURL != "" {
+		s.baseURL += id + "/"
+	}
+	m.mu.Lock()
+	m.versions[id] = s
+	m.mu.Unlock()
+}
+
+// RemoveVersion removes the version with the given id, along with its cache.
+func (m *VersionedMount) RemoveVersion(id string) {
+	m.mu.Lock()
+	delete(m.versions, id)
+	m.mu.Unlock()
+}
+
+func (m *VersionedMount) version(id string) *Server {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.versions[id]
+}
+
+// Path returns the path of filename in the given version, such as
+// "/v1/main.<hash>.js", as returned by MaybePath of the version's Server.
+func (m *VersionedMount) Path(id, filename string) (string, error) {
+	s := m.version(id)
+	if s == nil {
+		return "", fmt.Errorf("hashfs: unknown version %q", id)
+	}
+	return s.MaybePath(filename)
+}
+
+// ServeHTTP serves the request using the version in the first path segment.
+func (m *VersionedMount) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	id, _, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	s := m.version(id)
+	if s == nil {
+		http.NotFound(w, r)
+		return
+	}
+	s.ServeHTTP(w, r)
+}
@@ -0,0 +1,68 @@
+package hashfs
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"testing/fstest"
+
+	"github.com/daaku/ensure"
+)
+
+func TestVersionedMount(t *testing.T) {
+	m := NewVersionedMount()
+	m.AddVersion("v1", fstest.MapFS{"main.js": {Data: []byte("v1")}})
+	m.AddVersion("v2", fstest.MapFS{"main.js": {Data: []byte("v2")}})
+
+	v1, err := m.Path("v1", "main.js")
+	ensure.Nil(t, err)
+	v2, err := m.Path("v2", "main.js")
+	ensure.Nil(t, err)
+	ensure.NotDeepEqual(t, v1, v2)
+
+	cases := []struct {
+		path string
+		code int
+		body string
+	}{
+		{v1, http.StatusOK, "v1"},
+		{v2, http.StatusOK, "v2"},
+		{"/v3/main.js", http.StatusNotFound, "404 page not found\n"},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest("GET", c.path, nil)
+		w := httptest.NewRecorder()
+		m.ServeHTTP(w, r)
+		ensure.DeepEqual(t, w.Code, c.code)
+		ensure.DeepEqual(t, w.Body.String(), c.body)
+	}
+
+	m.RemoveVersion("v1")
+	_, err = m.Path("v1", "main.js")
+	ensure.Err(t, err, regexp.MustCompile(`hashfs: unknown version "v1"`))
+	r := httptest.NewRequest("GET", v1, nil)
+	w := httptest.NewRecorder()
+	m.ServeHTTP(w, r)
+	ensure.DeepEqual(t, w.Code, http.StatusNotFound)
+}
+
+func TestVersionedMountOptions(t *testing.T) {
+	fsys := fstest.MapFS{"main.js": {Data: []byte("main")}}
+	m := NewVersionedMount(WithDevMode())
+	m.AddVersion("v1", fsys)
+	p, err := m.Path("v1", "main.js")
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, p, "/v1/main.js")
+	r := httptest.NewRequest("GET", p, nil)
+	w := httptest.NewRecorder()
+	m.ServeHTTP(w, r)
+	ensure.DeepEqual(t, w.Code, http.StatusOK)
+	ensure.DeepEqual(t, w.Body.String(), "main")
+
+	m = NewVersionedMount(WithBaseURL("https://cdn/"))
+	m.AddVersion("v1", fsys)
+	p, err = m.Path("v1", "main.js")
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, p, "https://cdn/v1/main.0d6e4079e367.js")
+}
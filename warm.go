@@ -0,0 +1,120 @@
+package hashfs
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// WarmProgress is the progress of hashing the file system in the background.
+type WarmProgress struct {
+	Files  int   // the number of files hashed, including those which failed
+	Total  int   // the number of files to hash
+	Bytes  int64 // the size of the files hashed
+	Errors int   // the number of files which failed to hash
+	Done   bool  // true once all files were hashed or the context is done
+}
+
+// Warmup is a background hashing of the file system started by Warm.
+type Warmup struct {
+	mu       sync.Mutex
+	progress WarmProgress
+	errs     []error
+	done     chan struct{}
+}
+
+// Progress returns a snapshot of the progress, such as for health endpoints.
+func (w *Warmup) Progress() WarmProgress {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.progress
+}
+
+// Done returns a channel which is closed when the warmup finishes.
+func (w *Warmup) Done() <-chan struct{} {
+	return w.done
+}
+
+// Wait waits for the warmup to finish and returns the errors for files which
+// failed to hash, joined together.
+func (w *Warmup) Wait() error {
+	<-w.done
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return errors.Join(w.errs...)
+}
+
+// Warm hashes every file in the file system in the background, like
+// Precompute, so a server can accept requests right away. Requests for files
+// which are not hashed yet hash them as usual. The progress function, if not
+// nil, is called after each file and once more when done. Files are hashed in
+// the order configured by WithPriority, and the file configured by
+// WithHashCacheFile is written once done. Canceling the context stops the
+// warmup early.
+func (s *Server) Warm(ctx context.Context, progress func(WarmProgress)) *Warmup {
+	w := &Warmup{done: make(chan struct{})}
+	go s.warm(ctx, w, progress)
+	return w
+}
+
+func (s *Server) warm(ctx context.Context, w *Warmup, progress func(WarmProgress)) {
+	defer close(w.done)
+	report := func(update func(p *WarmProgress)) {
+		w.mu.Lock()
+		update(&w.progress)
+		p := w.progress
+		w.mu.Unlock()
+		if progress != nil {
+			progress(p)
+		}
+	}
+
+	files, err := s.files(ctx)
+	if err != nil {
+		w.mu.Lock()
+		w.errs = append(w.errs, err)
+		w.mu.Unlock()
+		report(func(p *WarmProgress) { p.Done = true })
+		return
+	}
+	w.mu.Lock()
+	w.progress.Total = len(files)
+	w.mu.Unlock()
+
+	for _, f := range files {
+		if ctx.Err() != nil {
+			break
+		}
+		_, err := s.hashContext(ctx, f.name)
+		var size int64
+		if fi, err := f.d.Info(); err == nil {
+			size = fi.Size()
+		}
+		if err != nil {
+			w.mu.Lock()
+			w.errs = append(w.errs, err)
+			w.mu.Unlock()
+		}
+		report(func(p *WarmProgress) {
+			p.Files++
+			if err != nil {
+				p.Errors++
+			} else {
+				p.Bytes += size
+			}
+		})
+	}
+	if err := ctx.Err(); err != nil {
+		w.mu.Lock()
+		w.errs = append(w.errs, err)
+		w.mu.Unlock()
+	}
+	if s.hashCache != nil {
+		if err := s.SaveHashCache(); err != nil {
+			w.mu.Lock()
+			w.errs = append(w.errs, err)
+			w.mu.Unlock()
+		}
+	}
+	report(func(p *WarmProgress) { p.Done = true })
+}
@@ -0,0 +1,51 @@
+package hashfs
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"testing/fstest"
+
+	"github.com/daaku/ensure"
+)
+
+func TestWarm(t *testing.T) {
+	fsys := &countingFS{FS: fstest.MapFS{
+		"a.txt":     {Data: []byte("a")},
+		"b/c.txt":   {Data: []byte("cc")},
+		".DS_Store": {Data: []byte("store")},
+	}}
+	s := New(fsys, WithHideDotfiles())
+	var updates []WarmProgress
+	w := s.Warm(context.Background(), func(p WarmProgress) {
+		updates = append(updates, p)
+	})
+	ensure.Nil(t, w.Wait())
+	ensure.DeepEqual(t, updates, []WarmProgress{
+		{Files: 1, Total: 2, Bytes: 1},
+		{Files: 2, Total: 2, Bytes: 3},
+		{Files: 2, Total: 2, Bytes: 3, Done: true},
+	})
+	ensure.DeepEqual(t, w.Progress(), updates[2])
+
+	// files are served without hashing again
+	opens := fsys.opens.Load()
+	ensure.DeepEqual(t, s.Path("b/c.txt"), "b/c.355b1bbfc967.txt")
+	ensure.DeepEqual(t, fsys.opens.Load(), opens)
+}
+
+func TestWarmCanceled(t *testing.T) {
+	fsys := &countingFS{FS: fstest.MapFS{
+		"a.txt": {Data: []byte("a")},
+		"b.txt": {Data: []byte("b")},
+	}}
+	ctx, cancel := context.WithCancel(context.Background())
+	w := New(fsys).Warm(ctx, func(p WarmProgress) {
+		cancel()
+	})
+	<-w.Done()
+	ensure.True(t, errors.Is(w.Wait(), context.Canceled))
+	p := w.Progress()
+	ensure.True(t, p.Done)
+	ensure.DeepEqual(t, p.Files, 1)
+}
@@ -0,0 +1,82 @@
+// Package watch invalidates the caches of a hashfs.Server when files in the
+// directory backing it change, for use in live reload development workflows.
+package watch
+
+import (
+	"io/fs"
+	"path/filepath"
+
+	"github.com/daaku/hashfs"
+	"github.com/fsnotify/fsnotify"
+)
+
+// Watcher watches a directory and invalidates a Server's caches on changes.
+type Watcher struct {
+	w    *fsnotify.Watcher
+	s    *hashfs.Server
+	dir  string
+	done chan struct{}
+}
+
+// New starts watching dir recursively and invalidating the cached data in s
+// for changed files. The Server should be backed by os.DirFS(dir).
+func New(s *hashfs.Server, dir string) (*Watcher, error) {
+	fw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	w := &Watcher{
+		w:    fw,
+		s:    s,
+		dir:  dir,
+		done: make(chan struct{}),
+	}
+	if err := w.add(dir); err != nil {
+		fw.Close()
+		return nil, err
+	}
+	go w.run()
+	return w, nil
+}
+
+// add watches dir and all directories within it.
+func (w *Watcher) add(dir string) error {
+	return filepath.WalkDir(dir, func(name string, d fs.DirEntry, err error) error {
+		if err != nil || !d.IsDir() {
+			return err
+		}
+		return w.w.Add(name)
+	})
+}
+
+func (w *Watcher) run() {
+	defer close(w.done)
+	for {
+		select {
+		case event, ok := <-w.w.Events:
+			if !ok {
+				return
+			}
+			if event.Has(fsnotify.Create) {
+				// new directories must be watched too, which is a noop for files
+				w.add(event.Name)
+			}
+			name, err := filepath.Rel(w.dir, event.Name)
+			if err != nil {
+				continue
+			}
+			w.s.Invalidate(filepath.ToSlash(name))
+		case _, ok := <-w.w.Errors:
+			if !ok {
+				return
+			}
+		}
+	}
+}
+
+// Close stops watching.
+func (w *Watcher) Close() error {
+	err := w.w.Close()
+	<-w.done
+	return err
+}
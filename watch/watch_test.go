@@ -0,0 +1,33 @@
+package watch
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/daaku/ensure"
+	"github.com/daaku/hashfs"
+)
+
+func TestWatch(t *testing.T) {
+	dir := t.TempDir()
+	ensure.Nil(t, os.Mkdir(filepath.Join(dir, "sub"), 0o755))
+	name := filepath.Join(dir, "sub", "main.js")
+	ensure.Nil(t, os.WriteFile(name, []byte("main"), 0o644))
+
+	s := hashfs.New(os.DirFS(dir))
+	original := s.Path("sub/main.js")
+	w, err := New(s, dir)
+	ensure.Nil(t, err)
+	defer w.Close()
+
+	ensure.Nil(t, os.WriteFile(name, []byte("changed"), 0o644))
+	deadline := time.Now().Add(5 * time.Second)
+	for s.Path("sub/main.js") == original {
+		if time.Now().After(deadline) {
+			t.Fatal("cache was not invalidated")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}